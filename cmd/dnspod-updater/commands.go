@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+)
+
+type command struct {
+	name string
+	args string
+	help string
+	run  func(c command, path string, args []string) int
+}
+
+// commands are the subcommands; without one the binary runs the updater.
+var commands = []command{
+	{name: "init", args: "[-out file]", help: "interactively create a config file", run: runInit},
+	{name: "validate", help: "check the config and exit", run: runValidate},
+	{name: "list-domains", args: "[-keyword k]", help: "list the domains of the account", run: runListDomains},
+	{name: "list-records", args: "[domain] [-sub name] [-type A]", help: "list the records of a domain", run: runListRecords},
+	{name: "get", args: "<record-id> [-domain d]", help: "show a record", run: runGet},
+	{name: "set", args: "<record-id|sub-domain> <value> [-domain d] [-type A]", help: "change the value of a record", run: runSet},
+	{name: "detect", help: "show what each IP detection method finds", run: runDetect},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns the flag set of a subcommand with the -o flag.
+func newFlagSet(c command) (*flag.FlagSet, *string) {
+	fs := commandFlagSet(c)
+	return fs, fs.String("o", "table", "output format: table or json")
+}
+
+func commandFlagSet(c command) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dnspod-updater [-config file] %s %s\n\n%s\n\nFlags:\n", c.name, c.args, c.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags that may appear before or after the positional
+// arguments, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// setup parses the arguments of c, checks the output format and the number
+// of positional arguments, and loads the account config. extra registers
+// command-specific flags. If ok is false the command should exit with code,
+// which is 0 after -h.
+func setup(c command, path string, args []string, minArgs, maxArgs int, extra func(fs *flag.FlagSet)) (cfg config.Config, pos []string, jsonOut bool, code int, ok bool) {
+	fs, output := newFlagSet(c)
+	if extra != nil {
+		extra(fs)
+	}
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return config.Config{}, nil, false, 0, false
+		}
+		return config.Config{}, nil, false, exitError, false
+	}
+	if len(pos) < minArgs || len(pos) > maxArgs {
+		fs.Usage()
+		return config.Config{}, nil, false, exitError, false
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "-o must be table or json, got %q\n", *output)
+		return config.Config{}, nil, false, exitError, false
+	}
+	cfg, err = config.LoadAccount(path)
+	if err != nil {
+		printConfigErrors(err)
+		return config.Config{}, nil, false, exitConfig, false
+	}
+	return cfg, pos, *output == "json", 0, true
+}
+
+func printConfigErrors(err error) {
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		errs = config.Errors{err}
+	}
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, "invalid config:", e)
+	}
+}
+
+// commandContext is canceled on Ctrl-C.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// commonRequest returns the request parameters for domain, a name or a
+// numeric id. An empty domain uses the configured one.
+func commonRequest(cfg config.Config, domain string) dnspod.CommonRequest {
+	req := dnspod.CommonRequest{
+		LoginToken:   cfg.LoginToken,
+		Format:       cfg.Format,
+		Lang:         cfg.Lang,
+		ErrorOnEmpty: cfg.ErrorOnEmpty,
+		Domain:       cfg.Domain,
+		DomainID:     cfg.DomainID,
+	}
+	if domain != "" {
+		req.Domain, req.DomainID = domain, 0
+		if id, err := strconv.Atoi(domain); err == nil {
+			req.Domain, req.DomainID = "", id
+		}
+	}
+	return req
+}
+
+// fail prints err and returns the matching exit code.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return exitCode(err)
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// printTable writes rows under header, aligned in columns.
+func printTable(w io.Writer, header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
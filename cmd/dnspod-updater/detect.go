@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/ipdetect"
+)
+
+// detectResult is the JSON output of the detect command.
+type detectResult struct {
+	Method string `json:"method"`
+	IP     string `json:"ip,omitempty"`
+	Source string `json:"source,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runDetect runs every IP detection method and the configured one. It does
+// not need a DNSPod token.
+func runDetect(c command, path string, args []string) int {
+	fs, output := newFlagSet(c)
+	getenv, err := config.Lookup(path)
+	if err != nil {
+		return fail(err)
+	}
+	// The flags override the config keys they stand for, so their values
+	// are parsed and checked like the config.
+	flags := []struct{ name, key, usage string }{
+		{"iface", "IP_PREFERRED_IFACE", "interface for the iface method"},
+		{"ssid", "WIFI_SSID", "WiFi SSID for the wifi method"},
+		{"method", "IP_DETECT_METHOD", "method used for the selected address"},
+		{"table", "IP_ROUTE_TABLE", "routing table for the route method (default: main)"},
+		{"fwmark", "IP_ROUTE_FWMARK", "follow the policy routing rules for this firewall mark"},
+		{"scope", "IP_SCOPE", "only accept `any`, public or private addresses"},
+		{"exclude", "IP_EXCLUDE_IFACES", "comma separated interface globs to skip, e.g. docker*,wg*"},
+	}
+	values := make(map[string]*string, len(flags))
+	for _, f := range flags {
+		values[f.key] = fs.String(f.name, getenv(f.key), f.usage)
+	}
+	pos, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil || len(pos) > 0 || (*output != "table" && *output != "json") {
+		fs.Usage()
+		return exitError
+	}
+	cfg, err := config.LoadDetection(func(key string) string {
+		if v, ok := values[key]; ok {
+			return *v
+		}
+		return getenv(key)
+	})
+	if err != nil {
+		printConfigErrors(err)
+		return exitConfig
+	}
+	opt := detectOptions(cfg)
+
+	var results []detectResult
+	ctx, cancel := commandContext()
+	defer cancel()
+	for _, r := range ipdetect.DetectAll(ctx, opt) {
+		results = append(results, newDetectResult(r.Method, r.IP.String(), r.Source, r.Err))
+	}
+	ip, src, err := ipdetect.NewDetector(opt).DetectIPv4(ctx)
+	selected := newDetectResult("selected", ip.String(), src, err)
+
+	if *output == "json" {
+		return printJSON(struct {
+			Methods  []detectResult `json:"methods"`
+			Selected detectResult   `json:"selected"`
+		}{results, selected})
+	}
+	var rows [][]string
+	for _, r := range append(results, selected) {
+		rows = append(rows, []string{r.Method, r.IP, r.Source, r.Error})
+	}
+	printTable(os.Stdout, []string{"METHOD", "IP", "SOURCE", "ERROR"}, rows)
+	if err != nil {
+		return exitError
+	}
+	return 0
+}
+
+// detectOptions returns the IP detection options of cfg. The updater and
+// the detect and init commands share it.
+func detectOptions(cfg config.Config) ipdetect.Options {
+	return ipdetect.Options{
+		PreferredIface: cfg.IPPreferredIface,
+		Method:         cfg.IPDetectMethod,
+		WiFiSSID:       cfg.WiFiSSID,
+		RouteTable:     cfg.IPRouteTable,
+		RouteFwmark:    cfg.IPRouteFwmark,
+		Filter: ipdetect.Filter{
+			Allow:         cfg.IPAllowCIDRs,
+			Deny:          cfg.IPDenyCIDRs,
+			ExcludeIfaces: cfg.IPExcludeIfaces,
+			Scope:         cfg.IPScope,
+		},
+	}
+}
+
+func newDetectResult(method, ip, src string, err error) detectResult {
+	if err != nil {
+		return detectResult{Method: method, Error: err.Error()}
+	}
+	return detectResult{Method: method, IP: ip, Source: src}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/ipdetect"
+	"github.com/hnrobert/dnspod-updater/internal/secret"
+)
+
+// runInit asks for the token, domain and record, and writes a config file
+// the updater can run with.
+func runInit(c command, path string, args []string) int {
+	fs := commandFlagSet(c)
+	defPath := path
+	if defPath == "" {
+		defPath = ".env"
+	}
+	out := fs.String("out", defPath, "file to write")
+	pos, err := parseArgs(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil || len(pos) > 0 {
+		fs.Usage()
+		return exitError
+	}
+	getenv, err := config.Lookup(path)
+	if err != nil {
+		return fail(err)
+	}
+	detectCfg, err := config.LoadDetection(getenv)
+	if err != nil {
+		printConfigErrors(err)
+		return exitConfig
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	w := &wizard{
+		in:     bufio.NewScanner(os.Stdin),
+		out:    os.Stdout,
+		getenv: getenv,
+		detect: detectOptions(detectCfg),
+		client: dnspod.NewClient(dnspod.ClientOptions{BaseURL: getenv("DNSPOD_BASE_URL")}),
+	}
+	settings, err := w.run(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	if err := w.write(*out, settings); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// wizard holds the state of the init dialog.
+type wizard struct {
+	in     *bufio.Scanner
+	out    io.Writer
+	getenv func(string) string
+	detect ipdetect.Options
+	client *dnspod.Client
+}
+
+// setting is a KEY=VALUE line of the written file.
+type setting struct{ key, value string }
+
+var (
+	errInputClosed = errors.New("input closed")
+	errAborted     = errors.New("aborted")
+)
+
+// ask prints question and returns the answer, or def for an empty answer.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	if !w.in.Scan() {
+		if err := w.in.Err(); err != nil {
+			return "", err
+		}
+		return "", errInputClosed
+	}
+	if v := strings.TrimSpace(w.in.Text()); v != "" {
+		return v, nil
+	}
+	return def, nil
+}
+
+// choose asks for a number between lo and hi.
+func (w *wizard) choose(question string, lo, hi, def int) (int, error) {
+	for {
+		v, err := w.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= lo && n <= hi {
+			return n, nil
+		}
+		fmt.Fprintf(w.out, "enter a number between %d and %d\n", lo, hi)
+	}
+}
+
+func (w *wizard) confirm(question string) (bool, error) {
+	v, err := w.ask(question+" [y/N]", "")
+	if err != nil {
+		return false, err
+	}
+	v = strings.ToLower(v)
+	return v == "y" || v == "yes", nil
+}
+
+func (w *wizard) run(ctx context.Context) ([]setting, error) {
+	req, domains, err := w.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(domains.Domains) == 0 {
+		return nil, errors.New("the account has no domains; add one in the DNSPod console first")
+	}
+
+	fmt.Fprintln(w.out, "\nDomains:")
+	for i, d := range domains.Domains {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, d.Name)
+	}
+	n, err := w.choose("Domain", 1, len(domains.Domains), 1)
+	if err != nil {
+		return nil, err
+	}
+	domain := domains.Domains[n-1]
+	req.DomainID = int(domain.ID)
+
+	ip, src, err := ipdetect.NewDetector(w.detect).DetectIPv4(ctx)
+	value, internal := "", ""
+	if err != nil {
+		fmt.Fprintf(w.out, "\nIP detection failed: %v\n", err)
+		fmt.Fprintln(w.out, "Run \"dnspod-updater detect\" for details; IP_PREFERRED_IFACE or IP_DETECT_METHOD may be needed.")
+	} else {
+		value = ip.String()
+		fmt.Fprintf(w.out, "\nDetected address: %s (%s)\n", value, src)
+		if class := ipdetect.Classify(ip); class != ipdetect.ClassPublic {
+			internal = "true"
+			fmt.Fprintf(w.out, "This is a %s address, so the config marks the record internal (DNSPOD_RECORD_INTERNAL=true).\nRemove that line if the record is meant to be public.\n", class)
+		}
+	}
+
+	records, err := w.client.RecordList(ctx, req, dnspod.RecordListParams{RecordType: "A", Length: maxRecordList})
+	if err != nil && dnspod.KindOf(err) != dnspod.KindRecordNotFound {
+		return nil, err
+	}
+	fmt.Fprintf(w.out, "\nA records of %s:\n  0) create a new record\n", domain.Name)
+	for i, r := range records.Records {
+		fmt.Fprintf(w.out, "  %d) %s  %s  %s\n", i+1, r.Name, r.Value, r.Line)
+	}
+	n, err = w.choose("Record", 0, len(records.Records), min(1, len(records.Records)))
+	if err != nil {
+		return nil, err
+	}
+
+	var id, sub, lineID string
+	if n > 0 {
+		r := records.Records[n-1]
+		id, sub, lineID = r.ID.String(), r.Name.String(), r.LineID.String()
+	} else {
+		if id, sub, lineID, err = w.create(ctx, req, domain.Grade.String(), value); err != nil {
+			return nil, err
+		}
+	}
+
+	return []setting{
+		{"DNSPOD_LOGIN_TOKEN", req.LoginToken.Reveal()},
+		{"DNSPOD_DOMAIN", domain.Name.String()},
+		{"DNSPOD_DOMAIN_ID", domain.ID.String()},
+		{"DNSPOD_SUB_DOMAIN", sub},
+		{"DNSPOD_RECORD_TYPE", "A"},
+		{"DNSPOD_RECORD_ID", id},
+		{"DNSPOD_RECORD_LINE_ID", lineID},
+		{"DNSPOD_RECORD_INTERNAL", internal},
+		{"CHECK_INTERVAL", "5m"},
+	}, nil
+}
+
+// login asks for the token until Domain.List accepts it.
+func (w *wizard) login(ctx context.Context) (dnspod.CommonRequest, dnspod.DomainListResponse, error) {
+	fmt.Fprintln(w.out, "Create an API token at https://console.dnspod.cn/account/token/token")
+	for {
+		tok, err := w.ask("DNSPod token (id,token)", "")
+		if err != nil {
+			return dnspod.CommonRequest{}, dnspod.DomainListResponse{}, err
+		}
+		if id, t, ok := strings.Cut(tok, ","); !ok || strings.TrimSpace(id) == "" || strings.TrimSpace(t) == "" {
+			fmt.Fprintln(w.out, "the token must have the form id,token")
+			continue
+		}
+		req := dnspod.CommonRequest{LoginToken: secret.New(tok), Format: "json", Lang: "cn", ErrorOnEmpty: "no"}
+		domains, err := w.client.DomainList(ctx, req, dnspod.DomainListParams{})
+		if dnspod.KindOf(err) == dnspod.KindAuth {
+			fmt.Fprintf(w.out, "token rejected: %v\n", err)
+			continue
+		}
+		if err != nil {
+			return dnspod.CommonRequest{}, dnspod.DomainListResponse{}, err
+		}
+		return req, domains, nil
+	}
+}
+
+// create asks for the sub-domain and line of a new A record and creates it.
+func (w *wizard) create(ctx context.Context, req dnspod.CommonRequest, grade, value string) (id, sub, lineID string, err error) {
+	if sub, err = w.ask("Sub-domain (@ for the apex)", "@"); err != nil {
+		return "", "", "", err
+	}
+	if value, err = w.ask("Initial value", value); err != nil {
+		return "", "", "", err
+	}
+
+	lines, err := w.client.RecordLine(ctx, req, grade)
+	if err != nil {
+		return "", "", "", err
+	}
+	def := 1
+	fmt.Fprintln(w.out, "\nLines:")
+	for i, l := range lines.Lines {
+		fmt.Fprintf(w.out, "  %d) %s\n", i+1, l)
+		if l.String() == "默认" {
+			def = i + 1
+		}
+	}
+	line := "默认"
+	if len(lines.Lines) > 0 {
+		n, err := w.choose("Line", 1, len(lines.Lines), def)
+		if err != nil {
+			return "", "", "", err
+		}
+		line = lines.Lines[n-1].String()
+	}
+	lineID = lines.LineIDs[line].String()
+
+	ok, err := w.confirm(fmt.Sprintf("Create A record %s -> %s on line %s?", sub, value, line))
+	if err != nil {
+		return "", "", "", err
+	}
+	if !ok {
+		return "", "", "", errAborted
+	}
+	res, err := w.client.RecordCreate(ctx, req, dnspod.ModifyRecordParams{
+		SubDomain:    sub,
+		RecordType:   "A",
+		RecordLine:   line,
+		RecordLineID: lineID,
+		Value:        value,
+	})
+	if err != nil {
+		return "", "", "", err
+	}
+	fmt.Fprintf(w.out, "created record %s\n", res.Record.ID)
+	return res.Record.ID.String(), sub, lineID, nil
+}
+
+// write saves settings to path after confirming an overwrite, and checks
+// that the result loads.
+func (w *wizard) write(path string, settings []setting) error {
+	if _, err := os.Stat(path); err == nil {
+		ok, err := w.confirm(fmt.Sprintf("\n%s exists. Overwrite?", path))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errAborted
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by dnspod-updater init on %s.\n# See .env.example for the other settings.\n", time.Now().Format("2006-01-02"))
+	for _, s := range settings {
+		if s.value != "" {
+			fmt.Fprintf(&b, "%s=%s\n", s.key, s.value)
+		}
+	}
+	// The file holds the token.
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	if _, err := config.Load(path); err != nil {
+		return fmt.Errorf("wrote %s, but it does not load: %w", path, err)
+	}
+	fmt.Fprintf(w.out, "\nWrote %s. Start with:\n  dnspod-updater -config %s -dry-run\n", path, path)
+	return nil
+}
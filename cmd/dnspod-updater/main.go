@@ -3,56 +3,168 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/hnrobert/dnspod-updater/internal/config"
 	"github.com/hnrobert/dnspod-updater/internal/dnspod"
 	"github.com/hnrobert/dnspod-updater/internal/ipdetect"
+	"github.com/hnrobert/dnspod-updater/internal/logging"
+	"github.com/hnrobert/dnspod-updater/internal/probe"
+	"github.com/hnrobert/dnspod-updater/internal/server"
+	"github.com/hnrobert/dnspod-updater/internal/state"
 	"github.com/hnrobert/dnspod-updater/internal/updater"
 )
 
+// Exit codes.
+const (
+	exitError          = 1
+	exitConfig         = 2
+	exitChangesPending = 3
+	exitAuth           = 4
+)
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	dryRun := flag.Bool("dry-run", false, "detect and print planned changes without writing (same as DRY_RUN=true)")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "KEY=VALUE config file, reloaded on SIGHUP and on change (same as CONFIG_FILE)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if name := flag.Arg(0); name != "" {
+		c, ok := findCommand(name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+			usage()
+			os.Exit(exitError)
+		}
+		os.Exit(c.run(c, *configFile, flag.Args()[1:]))
+	}
 
-	cfg, err := config.FromEnv()
+	cfg, err := config.Load(*configFile)
 	if err != nil {
-		log.Printf("config error: %v", err)
-		os.Exit(2)
+		slog.Error("config error", "error", err)
+		os.Exit(exitConfig)
+	}
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		slog.Error("config error", "error", err)
+		os.Exit(exitConfig)
+	}
+	slog.SetDefault(logger)
+	if *dryRun {
+		cfg.DryRun = true
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	ipDetector := ipdetect.NewDetector(ipdetect.Options{
-		PreferredIface: cfg.IPPreferredIface,
-		Method:         cfg.IPDetectMethod,
-		WiFiSSID:       cfg.WiFiSSID,
-	})
+	st, err := state.Open(cfg.StateFile)
+	if err != nil {
+		logger.Error("state error", "error", err)
+		os.Exit(exitConfig)
+	}
+
+	opt := updaterOptions(cfg)
+	opt.Logger = logger
+	opt.State = st
+	u := updater.New(opt)
 
-	client := dnspod.NewClient(dnspod.ClientOptions{
-		HTTPTimeout: cfg.HTTPTimeout,
-		BaseURL:     cfg.DNSPodBaseURL,
-		UserAgent:   cfg.UserAgent,
-	})
+	if cfg.ListenAddr != "" {
+		handler := server.New(server.Options{
+			Updater:      u,
+			TriggerToken: cfg.TriggerToken,
+		})
+		srv := &http.Server{Addr: cfg.ListenAddr, Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			logger.Info("http server listening", "addr", cfg.ListenAddr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("http server error", "error", err)
+			}
+		}()
+		defer srv.Close()
+	}
 
-	u := updater.New(updater.Options{
-		Config:     cfg,
-		Detector:   ipDetector,
-		DNSPod:     client,
-		Logger:     log.Default(),
-		StartDelay: cfg.StartDelay,
-	})
+	// SIGUSR1 forces an immediate check.
+	usr1 := make(chan os.Signal, 1)
+	notifyTrigger(usr1)
+	go func() {
+		for range usr1 {
+			go func() {
+				if err := u.Trigger(ctx); err != nil {
+					logger.Warn("SIGUSR1 check failed", "error", err)
+				}
+			}()
+		}
+	}()
+
+	go watchConfig(ctx, u, *configFile, cfg)
 
 	if err := u.Run(ctx); err != nil {
 		if errors.Is(err, context.Canceled) {
 			return
 		}
-		log.Printf("fatal: %v", err)
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		if errors.Is(err, updater.ErrChangesPending) {
+			os.Exit(exitChangesPending)
+		}
+		logger.Error("fatal", "error", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// updaterOptions builds the components that depend on cfg. main and config
+// reloads share it.
+func updaterOptions(cfg config.Config) updater.Options {
+	ipDetector := ipdetect.NewDetector(detectOptions(cfg))
+
+	var prober updater.HealthProber
+	if cfg.UpdateMode == config.ModeFailover {
+		prober = probe.New(probe.Options{
+			Type:    cfg.HealthCheckType,
+			Port:    cfg.HealthCheckPort,
+			Path:    cfg.HealthCheckPath,
+			Timeout: cfg.HealthCheckTimeout,
+		})
+	}
+
+	return updater.Options{
+		Config:     cfg,
+		Detector:   ipDetector,
+		DNSPod:     newClient(cfg),
+		StartDelay: cfg.StartDelay,
+		Prober:     prober,
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: dnspod-updater [flags] [command]
+
+Without a command, runs the updater.
+
+Commands:
+`)
+	for _, c := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-13s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nRun dnspod-updater <command> -h for the arguments of a command.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func exitCode(err error) int {
+	if errors.Is(err, updater.ErrPreflight) {
+		return exitConfig
+	}
+	switch kind := dnspod.KindOf(err); {
+	case kind == dnspod.KindAuth:
+		return exitAuth
+	case kind.Fatal(), kind == dnspod.KindRecordNotFound:
+		return exitConfig
+	default:
+		return exitError
 	}
 }
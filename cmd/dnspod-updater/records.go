@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+)
+
+// maxRecordList is the largest page Record.List accepts.
+const maxRecordList = 3000
+
+func runListDomains(c command, path string, args []string) int {
+	var keyword string
+	cfg, _, jsonOut, code, ok := setup(c, path, args, 0, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&keyword, "keyword", "", "only list domains containing this keyword")
+	})
+	if !ok {
+		return code
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	res, err := newClient(cfg).DomainList(ctx, commonRequest(cfg, ""), dnspod.DomainListParams{Keyword: keyword})
+	if err != nil {
+		return fail(err)
+	}
+	if jsonOut {
+		return printJSON(res.Domains)
+	}
+	var rows [][]string
+	for _, d := range res.Domains {
+		rows = append(rows, []string{d.ID.String(), d.Name.String(), d.Status.String(), d.Grade.String(), d.Records.String()})
+	}
+	printTable(os.Stdout, []string{"ID", "NAME", "STATUS", "GRADE", "RECORDS"}, rows)
+	return 0
+}
+
+func runListRecords(c command, path string, args []string) int {
+	var sub, typ string
+	cfg, pos, jsonOut, code, ok := setup(c, path, args, 0, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&sub, "sub", "", "only list records of this sub-domain")
+		fs.StringVar(&typ, "type", "", "only list records of this type")
+	})
+	if !ok {
+		return code
+	}
+	domain := ""
+	if len(pos) == 1 {
+		domain = pos[0]
+	}
+	req := commonRequest(cfg, domain)
+	if req.Domain == "" && req.DomainID == 0 {
+		fmt.Fprintln(os.Stderr, "no domain given and none configured")
+		return exitError
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	res, err := newClient(cfg).RecordList(ctx, req, dnspod.RecordListParams{
+		Length:     maxRecordList,
+		SubDomain:  sub,
+		RecordType: typ,
+	})
+	if err != nil && dnspod.KindOf(err) != dnspod.KindRecordNotFound {
+		return fail(err)
+	}
+	if jsonOut {
+		if res.Records == nil {
+			return printJSON([]any{})
+		}
+		return printJSON(res.Records)
+	}
+	var rows [][]string
+	for _, r := range res.Records {
+		rows = append(rows, recordRow(r))
+	}
+	printTable(os.Stdout, recordHeader, rows)
+	return 0
+}
+
+var recordHeader = []string{"ID", "NAME", "TYPE", "LINE", "VALUE", "TTL", "STATUS", "REMARK"}
+
+func recordRow(r dnspod.Record) []string {
+	return []string{r.ID.String(), r.Name.String(), r.Type.String(), r.Line.String(), r.Value.String(), r.TTL.String(), r.Status.String(), r.Remark.String()}
+}
+
+func runGet(c command, path string, args []string) int {
+	var domain string
+	cfg, pos, jsonOut, code, ok := setup(c, path, args, 1, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&domain, "domain", "", "domain name or id (default: DNSPOD_DOMAIN / DNSPOD_DOMAIN_ID)")
+	})
+	if !ok {
+		return code
+	}
+	id, err := strconv.Atoi(pos[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid record id %q\n", pos[0])
+		return exitError
+	}
+	req := commonRequest(cfg, domain)
+	if req.Domain == "" && req.DomainID == 0 {
+		fmt.Fprintln(os.Stderr, "no domain: pass -domain or configure DNSPOD_DOMAIN")
+		return exitError
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	res, err := newClient(cfg).RecordInfo(ctx, req, id)
+	if err != nil {
+		return fail(err)
+	}
+	if jsonOut {
+		return printJSON(res.Record)
+	}
+	printTable(os.Stdout, recordHeader, [][]string{recordRow(res.Record)})
+	return 0
+}
+
+// setResult is the JSON output of the set command.
+type setResult struct {
+	RecordID int    `json:"record_id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	OldValue string `json:"old_value"`
+	Value    string `json:"value"`
+	Changed  bool   `json:"changed"`
+}
+
+func runSet(c command, path string, args []string) int {
+	var domain, typ string
+	cfg, pos, jsonOut, code, ok := setup(c, path, args, 2, 2, func(fs *flag.FlagSet) {
+		fs.StringVar(&domain, "domain", "", "domain name or id (default: DNSPOD_DOMAIN / DNSPOD_DOMAIN_ID)")
+		fs.StringVar(&typ, "type", "A", "record type, used to find the record by sub-domain")
+	})
+	if !ok {
+		return code
+	}
+	req := commonRequest(cfg, domain)
+	if req.Domain == "" && req.DomainID == 0 {
+		fmt.Fprintln(os.Stderr, "no domain: pass -domain or configure DNSPOD_DOMAIN")
+		return exitError
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	client := newClient(cfg)
+
+	id, err := strconv.Atoi(pos[0])
+	if err != nil {
+		id, err = findRecord(ctx, client, req, pos[0], typ)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	info, err := client.RecordInfo(ctx, req, id)
+	if err != nil {
+		return fail(err)
+	}
+	rec := info.Record
+	res := setResult{RecordID: id, Name: rec.Name.String(), Type: rec.Type.String(), OldValue: rec.Value.String(), Value: pos[1]}
+
+	// Writing the current value again would count towards DNSPod's lock
+	// for no-change modifications.
+	if strings.TrimSpace(res.OldValue) != res.Value {
+		p := dnspod.ModifyRecordParams{
+			SubDomain:    res.Name,
+			RecordType:   res.Type,
+			RecordLine:   rec.Line.String(),
+			RecordLineID: rec.LineID.String(),
+			Value:        res.Value,
+			MX:           int(rec.MX),
+			TTL:          int(rec.TTL),
+		}
+		if rec.Weight != nil {
+			w := int(*rec.Weight)
+			p.Weight = &w
+		}
+		_, err := client.RecordModify(ctx, req, id, p)
+		if err != nil {
+			return fail(err)
+		}
+		res.Changed = true
+	}
+
+	if jsonOut {
+		return printJSON(res)
+	}
+	if res.Changed {
+		fmt.Printf("record %d %s %s: %s -> %s\n", id, res.Name, res.Type, res.OldValue, res.Value)
+	} else {
+		fmt.Printf("record %d %s %s: already %s\n", id, res.Name, res.Type, res.Value)
+	}
+	return 0
+}
+
+// findRecord returns the id of the only record of the given sub-domain and
+// type.
+func findRecord(ctx context.Context, client *dnspod.Client, req dnspod.CommonRequest, sub, typ string) (int, error) {
+	res, err := client.RecordList(ctx, req, dnspod.RecordListParams{SubDomain: sub, RecordType: typ})
+	if err != nil && dnspod.KindOf(err) != dnspod.KindRecordNotFound {
+		return 0, err
+	}
+	switch len(res.Records) {
+	case 0:
+		return 0, fmt.Errorf("no %s record for %q", typ, sub)
+	case 1:
+		return int(res.Records[0].ID), nil
+	default:
+		ids := make([]string, len(res.Records))
+		for i, r := range res.Records {
+			ids[i] = r.ID.String()
+		}
+		return 0, fmt.Errorf("%d %s records for %q (ids %s); pass a record id", len(ids), typ, sub, strings.Join(ids, ", "))
+	}
+}
+
+// newClient returns a DNSPod client for cfg.
+func newClient(cfg config.Config) *dnspod.Client {
+	return dnspod.NewClient(dnspod.ClientOptions{
+		HTTPTimeout: cfg.HTTPTimeout,
+		BaseURL:     cfg.DNSPodBaseURL,
+		UserAgent:   cfg.UserAgent,
+
+		Retries:        cfg.HTTPRetries,
+		RetryBaseDelay: cfg.HTTPRetryBaseDelay,
+		RetryMaxDelay:  cfg.HTTPRetryMaxDelay,
+
+		Trace: cfg.DNSPodTrace,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/updater"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+// restartOnly lists fields that are read once at startup.
+var restartOnly = map[string]bool{
+	"ListenAddr":   true,
+	"TriggerToken": true,
+	"StateFile":    true,
+	"StartDelay":   true,
+	"OneShot":      true,
+	"DryRun":       true,
+	"LogLevel":     true,
+	"LogFormat":    true,
+}
+
+// watchConfig reloads the config on SIGHUP or when the modification time or
+// size of the config file or a *_FILE secret changes. path may be empty when
+// the config comes from the environment only. A new config is only applied
+// if it is valid.
+func watchConfig(ctx context.Context, u *updater.Updater, path string, cur config.Config) {
+	hup := make(chan os.Signal, 1)
+	notifyReload(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	last := statFiles(watchedFiles(path, cur))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading config", "path", path)
+		case <-ticker.C:
+			changed := ""
+			for _, f := range watchedFiles(path, cur) {
+				fi, err := os.Stat(f)
+				if err != nil {
+					continue
+				}
+				if prev, ok := last[f]; !ok || !fi.ModTime().Equal(prev.ModTime()) || fi.Size() != prev.Size() {
+					changed = f
+					break
+				}
+			}
+			if changed == "" {
+				continue
+			}
+			slog.Info("config file changed, reloading", "path", changed)
+		}
+
+		next, err := config.Load(path)
+		if err != nil {
+			// Remember the broken files so they are not reloaded again
+			// until they change.
+			last = statFiles(watchedFiles(path, cur))
+			slog.Error("reload: invalid config, keeping the current one", "error", err)
+			continue
+		}
+		last = statFiles(watchedFiles(path, next))
+		next.DryRun = cur.DryRun
+		changes := config.Diff(cur, next)
+		if len(changes) == 0 {
+			slog.Info("reload: no changes")
+			continue
+		}
+		for _, c := range changes {
+			slog.Info("reload: config changed", "change", c)
+		}
+		for _, c := range changes {
+			if field, _, _ := strings.Cut(c, ":"); restartOnly[field] {
+				slog.Warn("reload: field only takes effect after a restart", "field", field)
+			}
+		}
+
+		opt := updaterOptions(next)
+		if err := u.Reload(ctx, opt); err != nil {
+			return
+		}
+		cur = next
+	}
+}
+
+// watchedFiles returns the config file, if any, and the secret files cfg was
+// read from.
+func watchedFiles(path string, cfg config.Config) []string {
+	var files []string
+	if path != "" {
+		files = append(files, path)
+	}
+	return append(files, cfg.SecretFiles...)
+}
+
+func statFiles(files []string) map[string]os.FileInfo {
+	m := make(map[string]os.FileInfo, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			m[f] = fi
+		}
+	}
+	return m
+}
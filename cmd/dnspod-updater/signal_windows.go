@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyTrigger is a no-op: Windows has no SIGUSR1.
+func notifyTrigger(c chan<- os.Signal) {}
+
+// notifyReload is a no-op: the config file is still polled for changes.
+func notifyReload(c chan<- os.Signal) {}
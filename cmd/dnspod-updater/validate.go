@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+)
+
+// runValidate loads the config, prints every problem found and exits
+// without contacting DNSPod.
+func runValidate(c command, path string, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "usage: dnspod-updater [-config file] %s\n", c.name)
+		return exitError
+	}
+	if _, err := config.Load(path); err != nil {
+		printConfigErrors(err)
+		return exitConfig
+	}
+	fmt.Println("config ok")
+	return 0
+}
@@ -0,0 +1,42 @@
+// Command fake-dnspod serves an in-memory DNSPod API for local development.
+// Point the updater at it with DNSPOD_BASE_URL.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod/dnspodtest"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8053", "listen address")
+	token := flag.String("token", "1,dev", "accepted DNSPOD_LOGIN_TOKEN")
+	domain := flag.String("domain", "example.com", "domain to serve")
+	grade := flag.String("grade", "DP_Free", "domain grade, sets the minimum TTL")
+	sub := flag.String("sub", "www", "sub-domain of the initial A record")
+	value := flag.String("value", "192.0.2.1", "value of the initial A record")
+	flag.Parse()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := dnspodtest.NewUnstartedServer(*token)
+	srv.Listener.Close()
+	srv.Listener = ln
+	srv.AddDomain(*domain, *grade)
+	id := srv.AddRecord(*domain, dnspodtest.Record{Name: *sub, Type: "A", Value: *value})
+	srv.Start()
+	defer srv.Close()
+
+	fmt.Printf("DNSPOD_BASE_URL=%s\nDNSPOD_LOGIN_TOKEN=%s\nDNSPOD_DOMAIN=%s\nDNSPOD_SUB_DOMAIN=%s\nDNSPOD_RECORD_INTERNAL=true\n# record id %d\n", srv.URL, *token, *domain, *sub, id)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+}
@@ -1,17 +1,21 @@
 package config
 
 import (
-	"errors"
 	"fmt"
+	"net/netip"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/secret"
 )
 
 type Config struct {
 	// DNSPod common params
-	LoginToken   string
+	LoginToken   secret.String
 	Format       string
 	Lang         string
 	ErrorOnEmpty string
@@ -33,100 +37,415 @@ type Config struct {
 	MX           int
 	Status       string
 	Weight       int
+	// RecordInternal allows publishing private, CGNAT and other non-public
+	// addresses, for records of an internal zone.
+	RecordInternal bool
 
 	// Runtime
 	CheckInterval time.Duration
 	OneShot       bool
+	DryRun        bool
 	HTTPTimeout   time.Duration
 	StartDelay    time.Duration
+	// CheckTimeout bounds a whole check: detection and all DNSPod calls.
+	// 0 means no limit.
+	CheckTimeout time.Duration
+
+	// Embedded HTTP server (/metrics, /healthz, /readyz, /status); empty disables it.
+	ListenAddr string
+	// /readyz fails when the last successful check is older than this many intervals.
+	ReadyIntervals int
+	// Optional bearer token required by POST /trigger.
+	TriggerToken secret.String
+
+	// DNSPod HTTP retry
+	HTTPRetries        int
+	HTTPRetryBaseDelay time.Duration
+	HTTPRetryMaxDelay  time.Duration
 
 	// IP detection
 	IPPreferredIface string
 	IPDetectMethod   string
 	WiFiSSID         string
+	// Routing table and firewall mark used to find the default route.
+	IPRouteTable  int
+	IPRouteFwmark uint32
+	// Address selection filters applied to every detection method.
+	IPAllowCIDRs    []netip.Prefix
+	IPDenyCIDRs     []netip.Prefix
+	IPExcludeIfaces []string
+	IPScope         string
+
+	// Modification budget
+	StateFile          string
+	ModifyLimitPerHour int
+
+	// Disable the record after detection has failed for this long (0 = never).
+	OfflineDisableAfter time.Duration
+
+	// Update mode: "detect" (default) or "failover"
+	UpdateMode string
+
+	// Failover
+	FailoverPrimary          string
+	FailoverBackup           string
+	FailoverFailThreshold    int
+	FailoverRecoverThreshold int
+	HealthCheckType          string
+	HealthCheckPort          int
+	HealthCheckPath          string
+	HealthCheckTimeout       time.Duration
+
+	// Logging
+	LogLevel  string
+	LogFormat string
+	// Log DNSPod request forms and response bodies (token redacted).
+	DNSPodTrace bool
 
 	// Misc
 	UserAgent string
+
+	// SecretFiles lists the *_FILE paths credentials were read from, so they
+	// can be watched for rotation.
+	SecretFiles []string
 }
 
+const (
+	ModeDetect   = "detect"
+	ModeFailover = "failover"
+)
+
+// FromEnv reads the config from environment variables.
 func FromEnv() (Config, error) {
+	return load(os.Getenv, true)
+}
+
+// Load reads the config from environment variables overlaid with the
+// KEY=VALUE file at path. Values from the file take precedence, so editing
+// the file is enough for a reload to pick them up. An empty path is the same
+// as FromEnv.
+func Load(path string) (Config, error) {
+	getenv, err := Lookup(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return load(getenv, true)
+}
+
+// LoadAccount is like Load but does not require a target record, for
+// commands that only need to talk to the DNSPod API.
+func LoadAccount(path string) (Config, error) {
+	getenv, err := Lookup(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return load(getenv, false)
+}
+
+// LoadDetection reads only the IP detection settings from getenv, for
+// commands that detect the address without a DNSPod account. Other settings
+// are left at their zero values.
+func LoadDetection(getenv func(string) string) (Config, error) {
+	var cfg Config
+	e := &env{get: getenv}
+	loadDetection(e, &cfg)
+	validateDetection(e, cfg)
+	if len(e.errs) > 0 {
+		return Config{}, e.errs
+	}
+	return cfg, nil
+}
+
+// Lookup returns a getenv-like function that reads the KEY=VALUE file at
+// path, falling back to the environment. An empty path reads the environment
+// only.
+func Lookup(path string) (func(string) string, error) {
+	if path == "" {
+		return os.Getenv, nil
+	}
+	file, err := ReadEnvFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(key string) string {
+		if v, ok := file[key]; ok {
+			return v
+		}
+		return os.Getenv(key)
+	}, nil
+}
+
+// load parses the settings. target requires a domain to update.
+func load(getenv func(string) string, target bool) (Config, error) {
 	var cfg Config
+	e := &env{get: getenv}
+
+	cfg.LoginToken = secretEnv(e, "DNSPOD_LOGIN_TOKEN", &cfg.SecretFiles)
+	cfg.Format = envDefault(e, "DNSPOD_FORMAT", "json")
+	cfg.Lang = envDefault(e, "DNSPOD_LANG", "cn")
+	cfg.ErrorOnEmpty = envDefault(e, "DNSPOD_ERROR_ON_EMPTY", "no")
+	cfg.DNSPodBaseURL = envDefault(e, "DNSPOD_BASE_URL", "https://dnsapi.cn")
 
-	cfg.LoginToken = strings.TrimSpace(os.Getenv("DNSPOD_LOGIN_TOKEN"))
-	cfg.Format = envDefault("DNSPOD_FORMAT", "json")
-	cfg.Lang = envDefault("DNSPOD_LANG", "cn")
-	cfg.ErrorOnEmpty = envDefault("DNSPOD_ERROR_ON_EMPTY", "no")
-	cfg.DNSPodBaseURL = envDefault("DNSPOD_BASE_URL", "https://dnsapi.cn")
-
-	cfg.Domain = strings.TrimSpace(os.Getenv("DNSPOD_DOMAIN"))
-	cfg.DomainID = envIntDefault("DNSPOD_DOMAIN_ID", 0)
-	cfg.RecordID = envIntDefault("DNSPOD_RECORD_ID", 0)
-
-	cfg.SubDomain = envDefault("DNSPOD_SUB_DOMAIN", "@")
-	cfg.RecordType = strings.ToUpper(envDefault("DNSPOD_RECORD_TYPE", "A"))
-	cfg.RecordLine = envDefault("DNSPOD_RECORD_LINE", "默认")
-	cfg.RecordLineID = strings.TrimSpace(os.Getenv("DNSPOD_RECORD_LINE_ID"))
-	cfg.TTL = envIntDefault("DNSPOD_TTL", 0)
-	cfg.MX = envIntDefault("DNSPOD_MX", 0)
-	cfg.Status = envDefault("DNSPOD_STATUS", "enable")
-	cfg.Weight = envIntDefault("DNSPOD_WEIGHT", -1) // -1 means not set
-
-	cfg.CheckInterval = envDurationDefault("CHECK_INTERVAL", 0)
+	cfg.Domain = strings.TrimSpace(e.get("DNSPOD_DOMAIN"))
+	cfg.DomainID = envIntDefault(e, "DNSPOD_DOMAIN_ID", 0)
+	cfg.RecordID = envIntDefault(e, "DNSPOD_RECORD_ID", 0)
+
+	cfg.SubDomain = envDefault(e, "DNSPOD_SUB_DOMAIN", "@")
+	cfg.RecordType = strings.ToUpper(envDefault(e, "DNSPOD_RECORD_TYPE", "A"))
+	cfg.RecordLine = envDefault(e, "DNSPOD_RECORD_LINE", "默认")
+	cfg.RecordLineID = strings.TrimSpace(e.get("DNSPOD_RECORD_LINE_ID"))
+	cfg.TTL = envIntDefault(e, "DNSPOD_TTL", 0)
+	cfg.MX = envIntDefault(e, "DNSPOD_MX", 0)
+	cfg.Status = envDefault(e, "DNSPOD_STATUS", "enable")
+	cfg.Weight = envIntDefault(e, "DNSPOD_WEIGHT", -1) // -1 means not set
+	cfg.RecordInternal = envBoolDefault(e, "DNSPOD_RECORD_INTERNAL", false)
+
+	cfg.CheckInterval = envDurationDefault(e, "CHECK_INTERVAL", 0)
 	if cfg.CheckInterval == 0 {
 		// Compatibility: seconds-based env
-		sec := envIntDefault("CHECK_INTERVAL_SECONDS", 0)
+		sec := envIntDefault(e, "CHECK_INTERVAL_SECONDS", 0)
 		if sec > 0 {
 			cfg.CheckInterval = time.Duration(sec) * time.Second
 		}
 	}
-	cfg.OneShot = envBoolDefault("ONESHOT", false)
-	cfg.HTTPTimeout = envDurationDefault("HTTP_TIMEOUT", 10*time.Second)
-	cfg.StartDelay = envDurationDefault("START_DELAY", 0)
+	cfg.OneShot = envBoolDefault(e, "ONESHOT", false)
+	cfg.DryRun = envBoolDefault(e, "DRY_RUN", false)
+	cfg.HTTPTimeout = envDurationDefault(e, "HTTP_TIMEOUT", 10*time.Second)
+	cfg.StartDelay = envDurationDefault(e, "START_DELAY", 0)
+	cfg.CheckTimeout = envDurationDefault(e, "CHECK_TIMEOUT", 2*time.Minute)
+	cfg.ListenAddr = strings.TrimSpace(e.get("LISTEN_ADDR"))
+	cfg.ReadyIntervals = envIntDefault(e, "READY_INTERVALS", 3)
+	cfg.TriggerToken = secretEnv(e, "TRIGGER_TOKEN", &cfg.SecretFiles)
+	cfg.HTTPRetries = envIntDefault(e, "HTTP_RETRIES", 2)
+	cfg.HTTPRetryBaseDelay = envDurationDefault(e, "HTTP_RETRY_BASE_DELAY", 500*time.Millisecond)
+	cfg.HTTPRetryMaxDelay = envDurationDefault(e, "HTTP_RETRY_MAX_DELAY", 10*time.Second)
+
+	loadDetection(e, &cfg)
+
+	cfg.StateFile = envDefault(e, "STATE_FILE", defaultStateFile(e))
+	cfg.ModifyLimitPerHour = envIntDefault(e, "MODIFY_LIMIT_PER_HOUR", 5) // 0 disables the budget
 
-	cfg.IPPreferredIface = strings.TrimSpace(os.Getenv("IP_PREFERRED_IFACE"))
-	cfg.IPDetectMethod = strings.TrimSpace(os.Getenv("IP_DETECT_METHOD")) // "auto" (default), "route", "udp", "iface"
-	cfg.WiFiSSID = strings.TrimSpace(os.Getenv("WIFI_SSID"))
+	cfg.OfflineDisableAfter = envDurationDefault(e, "OFFLINE_DISABLE_AFTER", 0)
 
-	cfg.UserAgent = envDefault("USER_AGENT", "dnspod-updater/1.0")
+	cfg.UpdateMode = strings.ToLower(envDefault(e, "UPDATE_MODE", ModeDetect))
+	cfg.FailoverPrimary = strings.TrimSpace(e.get("FAILOVER_PRIMARY")) // empty means the detected IP
+	cfg.FailoverBackup = strings.TrimSpace(e.get("FAILOVER_BACKUP"))
+	cfg.FailoverFailThreshold = envIntDefault(e, "FAILOVER_FAIL_THRESHOLD", 3)
+	cfg.FailoverRecoverThreshold = envIntDefault(e, "FAILOVER_RECOVER_THRESHOLD", 3)
+	cfg.HealthCheckType = strings.ToLower(envDefault(e, "HEALTH_CHECK_TYPE", "tcp")) // "tcp" or "http"
+	cfg.HealthCheckPort = envIntDefault(e, "HEALTH_CHECK_PORT", 0)
+	cfg.HealthCheckPath = envDefault(e, "HEALTH_CHECK_PATH", "/")
+	cfg.HealthCheckTimeout = envDurationDefault(e, "HEALTH_CHECK_TIMEOUT", 3*time.Second)
+
+	cfg.LogLevel = strings.ToLower(envDefault(e, "LOG_LEVEL", "info"))   // debug/info/warn/error
+	cfg.LogFormat = strings.ToLower(envDefault(e, "LOG_FORMAT", "text")) // text/json
+
+	cfg.DNSPodTrace = envBoolDefault(e, "DNSPOD_TRACE", false)
+
+	cfg.UserAgent = envDefault(e, "USER_AGENT", "dnspod-updater/1.0")
+
+	validate(e, cfg, target)
+	if len(e.errs) > 0 {
+		return Config{}, e.errs
+	}
+	return cfg, nil
+}
 
-	if cfg.LoginToken == "" {
-		return Config{}, errors.New("DNSPOD_LOGIN_TOKEN is required (format: id,token)")
+// validate checks ranges and rules that span several settings.
+func validate(e *env, cfg Config, target bool) {
+	if cfg.LoginToken.IsZero() {
+		e.errorf("DNSPOD_LOGIN_TOKEN or DNSPOD_LOGIN_TOKEN_FILE is required (format: id,token)")
+	} else if id, token, ok := strings.Cut(cfg.LoginToken.Reveal(), ","); !ok || !allDigits(strings.TrimSpace(id)) || strings.TrimSpace(token) == "" {
+		e.errorf("DNSPOD_LOGIN_TOKEN must have the form id,token (the numeric token ID, a comma, then the token)")
 	}
-	if cfg.Domain == "" && cfg.DomainID == 0 {
-		return Config{}, errors.New("DNSPOD_DOMAIN or DNSPOD_DOMAIN_ID is required")
+	if target && cfg.Domain == "" && cfg.DomainID == 0 {
+		e.errorf("DNSPOD_DOMAIN or DNSPOD_DOMAIN_ID is required")
 	}
-	if cfg.RecordType == "MX" && cfg.MX == 0 {
-		return Config{}, errors.New("DNSPOD_MX is required when DNSPOD_RECORD_TYPE=MX")
+	if !recordTypes[cfg.RecordType] {
+		e.errorf("DNSPOD_RECORD_TYPE: unknown record type %q", cfg.RecordType)
+	}
+	if cfg.RecordType == "MX" && (cfg.MX < 1 || cfg.MX > 20) {
+		e.errorf("DNSPOD_MX must be between 1 and 20 when DNSPOD_RECORD_TYPE=MX, got %d", cfg.MX)
+	}
+	if cfg.TTL != 0 && (cfg.TTL < 1 || cfg.TTL > 604800) {
+		e.errorf("DNSPOD_TTL must be between 1 and 604800, got %d", cfg.TTL)
+	}
+	if cfg.Weight != -1 && (cfg.Weight < 0 || cfg.Weight > 100) {
+		e.errorf("DNSPOD_WEIGHT must be between 0 and 100, got %d", cfg.Weight)
+	}
+	if cfg.Status != "enable" && cfg.Status != "disable" {
+		e.errorf("DNSPOD_STATUS must be enable or disable, got %q", cfg.Status)
 	}
 	if cfg.CheckInterval < 0 {
-		return Config{}, fmt.Errorf("CHECK_INTERVAL must be >= 0, got %s", cfg.CheckInterval)
+		e.errorf("CHECK_INTERVAL must be >= 0, got %s", cfg.CheckInterval)
+	}
+	if cfg.HTTPTimeout <= 0 {
+		e.errorf("HTTP_TIMEOUT must be > 0, got %s", cfg.HTTPTimeout)
+	}
+	if cfg.CheckTimeout != 0 && cfg.CheckTimeout < cfg.HTTPTimeout {
+		e.errorf("CHECK_TIMEOUT must be 0 (no limit) or >= HTTP_TIMEOUT (%s), got %s", cfg.HTTPTimeout, cfg.CheckTimeout)
+	}
+	validateDetection(e, cfg)
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		e.errorf("LOG_LEVEL must be debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		e.errorf("LOG_FORMAT must be text or json, got %q", cfg.LogFormat)
+	}
+	if cfg.ReadyIntervals < 1 {
+		e.errorf("READY_INTERVALS must be >= 1, got %d", cfg.ReadyIntervals)
+	}
+	if cfg.HTTPRetries < 0 {
+		e.errorf("HTTP_RETRIES must be >= 0, got %d", cfg.HTTPRetries)
+	}
+	if target && cfg.StateFile == "" {
+		e.errorf("STATE_FILE is required when there is no home directory to keep the state in")
+	}
+	if cfg.ModifyLimitPerHour < 0 {
+		e.errorf("MODIFY_LIMIT_PER_HOUR must be >= 0, got %d", cfg.ModifyLimitPerHour)
+	}
+	if cfg.OfflineDisableAfter < 0 {
+		e.errorf("OFFLINE_DISABLE_AFTER must be >= 0, got %s", cfg.OfflineDisableAfter)
 	}
+	switch cfg.UpdateMode {
+	case ModeDetect:
+	case ModeFailover:
+		if cfg.FailoverBackup == "" {
+			e.errorf("FAILOVER_BACKUP is required when UPDATE_MODE=failover")
+		}
+		if cfg.HealthCheckPort <= 0 || cfg.HealthCheckPort > 65535 {
+			e.errorf("HEALTH_CHECK_PORT (1-65535) is required when UPDATE_MODE=failover")
+		}
+		if cfg.HealthCheckType != "tcp" && cfg.HealthCheckType != "http" {
+			e.errorf("HEALTH_CHECK_TYPE must be tcp or http, got %q", cfg.HealthCheckType)
+		}
+		if cfg.FailoverFailThreshold < 1 || cfg.FailoverRecoverThreshold < 1 {
+			e.errorf("FAILOVER_FAIL_THRESHOLD and FAILOVER_RECOVER_THRESHOLD must be >= 1")
+		}
+	default:
+		e.errorf("unknown UPDATE_MODE: %q", cfg.UpdateMode)
+	}
+}
 
-	return cfg, nil
+// loadDetection parses the IP detection settings.
+func loadDetection(e *env, cfg *Config) {
+	cfg.IPPreferredIface = strings.TrimSpace(e.get("IP_PREFERRED_IFACE"))
+	cfg.IPDetectMethod = strings.TrimSpace(e.get("IP_DETECT_METHOD")) // "auto" (default), "route", "udp", "iface"
+	cfg.WiFiSSID = strings.TrimSpace(e.get("WIFI_SSID"))
+	cfg.IPRouteTable = envIntDefault(e, "IP_ROUTE_TABLE", 0) // 0 means the main table
+	cfg.IPRouteFwmark = envFwmark(e, "IP_ROUTE_FWMARK")
+	cfg.IPAllowCIDRs = envPrefixes(e, "IP_ALLOW_CIDRS")
+	cfg.IPDenyCIDRs = envPrefixes(e, "IP_DENY_CIDRS")
+	cfg.IPExcludeIfaces = splitList(e.get("IP_EXCLUDE_IFACES")) // e.g. "docker*,veth*,wg*"
+	cfg.IPScope = envDefault(e, "IP_SCOPE", "any")
+}
+
+// validateDetection checks the IP detection settings.
+func validateDetection(e *env, cfg Config) {
+	switch cfg.IPDetectMethod {
+	case "", "auto", "route", "udp":
+	case "iface":
+		if cfg.IPPreferredIface == "" {
+			e.errorf("IP_PREFERRED_IFACE is required when IP_DETECT_METHOD=iface")
+		}
+	default:
+		e.errorf("IP_DETECT_METHOD must be auto, route, udp or iface, got %q", cfg.IPDetectMethod)
+	}
+	if cfg.IPRouteTable < 0 {
+		e.errorf("IP_ROUTE_TABLE must be a table id >= 0, got %d", cfg.IPRouteTable)
+	}
+	if cfg.IPRouteTable != 0 && cfg.IPRouteFwmark != 0 {
+		e.errorf("IP_ROUTE_TABLE and IP_ROUTE_FWMARK are mutually exclusive")
+	}
+	for _, pattern := range cfg.IPExcludeIfaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			e.errorf("IP_EXCLUDE_IFACES: %q is not a valid glob", pattern)
+		}
+	}
+	switch cfg.IPScope {
+	case "any", "public", "private":
+	default:
+		e.errorf("IP_SCOPE must be any, public or private, got %q", cfg.IPScope)
+	}
+}
+
+// recordTypes are the record types DNSPod accepts.
+var recordTypes = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "MX": true, "TXT": true, "NS": true,
+	"SRV": true, "CAA": true, "HTTPS": true, "SVCB": true, "显性URL": true, "隐性URL": true,
+}
+
+// Errors lists every invalid setting found while loading a config.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error { return e }
+
+// env reads settings through get and collects the problems found.
+type env struct {
+	get  func(string) string
+	errs Errors
+}
+
+func (e *env) errorf(format string, args ...any) {
+	e.errs = append(e.errs, fmt.Errorf(format, args...))
+}
+
+// secretEnv reads a credential from key, or from the file named by
+// key+"_FILE" following the Docker secrets convention. The file is read on
+// every load, so a config reload picks up rotated secrets. Its path is
+// appended to files.
+func secretEnv(e *env, key string, files *[]string) secret.String {
+	v := strings.TrimSpace(e.get(key))
+	path := strings.TrimSpace(e.get(key + "_FILE"))
+	if path == "" {
+		return secret.New(v)
+	}
+	if v != "" {
+		e.errorf("%s and %s_FILE are mutually exclusive", key, key)
+		return secret.String{}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		e.errorf("%s_FILE: %w", key, err)
+		return secret.String{}
+	}
+	*files = append(*files, path)
+	return secret.New(strings.TrimSpace(string(b)))
 }
 
-func envDefault(key, def string) string {
-	v := strings.TrimSpace(os.Getenv(key))
+func envDefault(e *env, key, def string) string {
+	v := strings.TrimSpace(e.get(key))
 	if v == "" {
 		return def
 	}
 	return v
 }
 
-func envIntDefault(key string, def int) int {
-	v := strings.TrimSpace(os.Getenv(key))
+func envIntDefault(e *env, key string, def int) int {
+	v := strings.TrimSpace(e.get(key))
 	if v == "" {
 		return def
 	}
 	n, err := strconv.Atoi(v)
 	if err != nil {
+		e.errorf("%s: %q is not an integer", key, v)
 		return def
 	}
 	return n
 }
 
-func envBoolDefault(key string, def bool) bool {
-	v := strings.TrimSpace(strings.ToLower(os.Getenv(key)))
+func envBoolDefault(e *env, key string, def bool) bool {
+	v := strings.TrimSpace(strings.ToLower(e.get(key)))
 	if v == "" {
 		return def
 	}
@@ -136,12 +455,82 @@ func envBoolDefault(key string, def bool) bool {
 	case "0", "false", "no", "n", "off":
 		return false
 	default:
+		e.errorf("%s: %q is not a boolean (use true or false)", key, v)
 		return def
 	}
 }
 
-func envDurationDefault(key string, def time.Duration) time.Duration {
-	v := strings.TrimSpace(os.Getenv(key))
+// envFwmark parses a firewall mark given in decimal or hex (0x...).
+func envFwmark(e *env, key string) uint32 {
+	v := strings.TrimSpace(e.get(key))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 0, 32)
+	if err != nil {
+		e.errorf("%s: %q is not a firewall mark (e.g. 51820 or 0xca6c)", key, v)
+		return 0
+	}
+	return uint32(n)
+}
+
+// envPrefixes parses a list of IPv4 CIDRs or addresses.
+func envPrefixes(e *env, key string) []netip.Prefix {
+	prefixes, err := parsePrefixes(e.get(key))
+	if err != nil {
+		e.errorf("%s: %v", key, err)
+		return nil
+	}
+	return prefixes
+}
+
+// parsePrefixes parses a comma or space separated list of IPv4 prefixes.
+// A bare address stands for a /32.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range splitList(list) {
+		var p netip.Prefix
+		var err error
+		if strings.Contains(s, "/") {
+			p, err = netip.ParsePrefix(s)
+		} else {
+			var a netip.Addr
+			a, err = netip.ParseAddr(s)
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("%s is not an IPv4 prefix", s)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// splitList splits a comma or space separated list and drops empty items.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// defaultStateFile returns $XDG_STATE_HOME/dnspod-updater/state.json, or
+// ~/.local/state/dnspod-updater/state.json, or "" if there is no home
+// directory.
+func defaultStateFile(e *env) string {
+	dir := strings.TrimSpace(e.get("XDG_STATE_HOME"))
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "dnspod-updater", "state.json")
+}
+
+func envDurationDefault(e *env, key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(e.get(key))
 	if v == "" {
 		return def
 	}
@@ -151,6 +540,7 @@ func envDurationDefault(key string, def time.Duration) time.Duration {
 	}
 	d, err := time.ParseDuration(v)
 	if err != nil {
+		e.errorf("%s: %q is not a duration (e.g. 300, 90s, 5m, 1h)", key, v)
 		return def
 	}
 	return d
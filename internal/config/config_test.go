@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookup returns a getenv for a valid minimal config with the settings in
+// over applied on top. An empty value unsets a key.
+func lookup(over map[string]string) func(string) string {
+	m := map[string]string{
+		"DNSPOD_LOGIN_TOKEN": "12345,abcdef",
+		"DNSPOD_DOMAIN":      "example.com",
+		"XDG_STATE_HOME":     "/var/lib",
+	}
+	for k, v := range over {
+		m[k] = v
+	}
+	return func(key string) string { return m[key] }
+}
+
+func TestLoadValid(t *testing.T) {
+	cfg, err := load(lookup(map[string]string{"CHECK_INTERVAL": "300", "DNSPOD_TTL": "600"}), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CheckInterval != 5*time.Minute || cfg.TTL != 600 || cfg.SubDomain != "@" || cfg.Weight != -1 {
+		t.Errorf("load() = %+v", cfg)
+	}
+	if cfg.StateFile != "/var/lib/dnspod-updater/state.json" {
+		t.Errorf("StateFile = %q", cfg.StateFile)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"missing token", map[string]string{"DNSPOD_LOGIN_TOKEN": ""}, "DNSPOD_LOGIN_TOKEN or DNSPOD_LOGIN_TOKEN_FILE is required"},
+		{"token without id", map[string]string{"DNSPOD_LOGIN_TOKEN": "abcdef"}, "DNSPOD_LOGIN_TOKEN must have the form id,token"},
+		{"token with a non-numeric id", map[string]string{"DNSPOD_LOGIN_TOKEN": "abc,def"}, "DNSPOD_LOGIN_TOKEN must have the form id,token"},
+		{"token without secret", map[string]string{"DNSPOD_LOGIN_TOKEN": "12345, "}, "DNSPOD_LOGIN_TOKEN must have the form id,token"},
+		{"missing domain", map[string]string{"DNSPOD_DOMAIN": ""}, "DNSPOD_DOMAIN or DNSPOD_DOMAIN_ID is required"},
+		{"bad duration", map[string]string{"CHECK_INTERVAL": "5 minutes"}, `CHECK_INTERVAL: "5 minutes" is not a duration`},
+		{"bad integer", map[string]string{"DNSPOD_TTL": "abc"}, `DNSPOD_TTL: "abc" is not an integer`},
+		{"ttl too small", map[string]string{"DNSPOD_TTL": "-5"}, "DNSPOD_TTL must be between 1 and 604800, got -5"},
+		{"ttl too large", map[string]string{"DNSPOD_TTL": "604801"}, "DNSPOD_TTL must be between 1 and 604800, got 604801"},
+		{"weight too large", map[string]string{"DNSPOD_WEIGHT": "101"}, "DNSPOD_WEIGHT must be between 0 and 100, got 101"},
+		{"negative weight", map[string]string{"DNSPOD_WEIGHT": "-2"}, "DNSPOD_WEIGHT must be between 0 and 100, got -2"},
+		{"mx without priority", map[string]string{"DNSPOD_RECORD_TYPE": "mx"}, "DNSPOD_MX must be between 1 and 20 when DNSPOD_RECORD_TYPE=MX, got 0"},
+		{"unknown record type", map[string]string{"DNSPOD_RECORD_TYPE": "PTR"}, `DNSPOD_RECORD_TYPE: unknown record type "PTR"`},
+		{"iface method without iface", map[string]string{"IP_DETECT_METHOD": "iface"}, "IP_PREFERRED_IFACE is required when IP_DETECT_METHOD=iface"},
+		{"unknown method", map[string]string{"IP_DETECT_METHOD": "dns"}, `IP_DETECT_METHOD must be auto, route, udp or iface, got "dns"`},
+		{"table and fwmark", map[string]string{"IP_ROUTE_TABLE": "100", "IP_ROUTE_FWMARK": "0xca6c"}, "IP_ROUTE_TABLE and IP_ROUTE_FWMARK are mutually exclusive"},
+		{"check timeout below http timeout", map[string]string{"CHECK_TIMEOUT": "5s"}, "CHECK_TIMEOUT must be 0 (no limit) or >= HTTP_TIMEOUT (10s), got 5s"},
+		{"failover without backup", map[string]string{"UPDATE_MODE": "failover", "HEALTH_CHECK_PORT": "443"}, "FAILOVER_BACKUP is required when UPDATE_MODE=failover"},
+		{"bad bool", map[string]string{"ONESHOT": "maybe"}, "ONESHOT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(lookup(tt.env), true)
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != 1 {
+				t.Fatalf("load() = %v, want a single error", err)
+			}
+			if !strings.Contains(errs[0].Error(), tt.want) {
+				t.Errorf("load() = %q, want %q", errs[0], tt.want)
+			}
+		})
+	}
+}
+
+// Every bad setting is reported at once, not just the first one.
+func TestLoadCollectsErrors(t *testing.T) {
+	_, err := load(lookup(map[string]string{
+		"CHECK_INTERVAL": "5 minutes",
+		"DNSPOD_TTL":     "abc",
+		"DNSPOD_WEIGHT":  "101",
+	}), true)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("load() = %v, want Errors", err)
+	}
+	want := []string{
+		`DNSPOD_TTL: "abc" is not an integer`,
+		`CHECK_INTERVAL: "5 minutes" is not a duration (e.g. 300, 90s, 5m, 1h)`,
+		"DNSPOD_WEIGHT must be between 0 and 100, got 101",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("load() = %d errors %q, want %d", len(errs), errs, len(want))
+	}
+	for i := range want {
+		if errs[i].Error() != want[i] {
+			t.Errorf("error %d = %q, want %q", i, errs[i], want[i])
+		}
+	}
+	if got, wantAll := err.Error(), strings.Join(want, "; "); got != wantAll {
+		t.Errorf("Error() = %q, want %q", got, wantAll)
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := parsePrefixes(" 10.0.0.0/8,192.168.1.7 100.64.1.0/10 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "100.64.0.0/10"}
+	if len(got) != len(want) {
+		t.Fatalf("parsePrefixes() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("parsePrefixes()[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "eth0", "fd00::/8"} {
+		if _, err := parsePrefixes(bad); err == nil {
+			t.Errorf("parsePrefixes(%q) succeeded", bad)
+		}
+	}
+}
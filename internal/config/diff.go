@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// secretFields are reported as changed without printing their values.
+var secretFields = map[string]bool{
+	"LoginToken":   true,
+	"TriggerToken": true,
+}
+
+// Diff lists the fields that differ between old and new, one
+// "Field: old -> new" entry per field.
+func Diff(old, new Config) []string {
+	var out []string
+	ov := reflect.ValueOf(old)
+	nv := reflect.ValueOf(new)
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		if secretFields[name] {
+			out = append(out, name+": (changed)")
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s: %v -> %v", name, a, b))
+	}
+	return out
+}
@@ -6,23 +6,42 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+	"github.com/hnrobert/dnspod-updater/internal/secret"
 )
 
 type ClientOptions struct {
 	HTTPTimeout time.Duration
 	BaseURL     string
 	UserAgent   string
+
+	// Retries is the number of extra attempts for retryable failures
+	// (network errors, HTTP 5xx and 429). 0 disables retrying.
+	Retries        int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// Trace logs every request form and response body, with the token
+	// redacted.
+	Trace bool
 }
 
 type Client struct {
 	baseURL   string
 	userAgent string
 	hc        *http.Client
+	retry     retryPolicy
+	log       *slog.Logger
+	trace     bool
 }
 
 func NewClient(opt ClientOptions) *Client {
@@ -38,42 +57,65 @@ func NewClient(opt ClientOptions) *Client {
 	if to == 0 {
 		to = 10 * time.Second
 	}
+	rp := retryPolicy{retries: opt.Retries, base: opt.RetryBaseDelay, max: opt.RetryMaxDelay}
+	if rp.base <= 0 {
+		rp.base = 500 * time.Millisecond
+	}
+	if rp.max <= 0 {
+		rp.max = 10 * time.Second
+	}
+	lg := opt.Logger
+	if lg == nil {
+		lg = slog.Default()
+	}
 	return &Client{
 		baseURL:   base,
 		userAgent: ua,
 		hc: &http.Client{
 			Timeout: to,
 		},
+		retry: rp,
+		log:   lg,
+		trace: opt.Trace,
 	}
 }
 
 type Status struct {
-	Code      string `json:"code"`
-	Message   string `json:"message"`
-	CreatedAt string `json:"created_at"`
+	Code      FlexString `json:"code"`
+	Message   FlexString `json:"message"`
+	CreatedAt FlexString `json:"created_at"`
+}
+
+// Record is a record as returned by Record.Info and Record.List.
+type Record struct {
+	ID     FlexInt    `json:"id"`
+	Name   FlexString `json:"name"`
+	Type   FlexString `json:"type"`
+	Line   FlexString `json:"line"`
+	LineID FlexString `json:"line_id"`
+	Value  FlexString `json:"value"`
+	TTL    FlexInt    `json:"ttl"`
+	MX     FlexInt    `json:"mx"`
+	// Weight is nil when the record has no weight set.
+	Weight    *FlexInt   `json:"weight"`
+	Status    FlexString `json:"status"`
+	Enabled   FlexBool   `json:"enabled"`
+	Remark    FlexString `json:"remark"`
+	UpdatedOn FlexString `json:"updated_on"`
 }
 
 type RecordInfoResponse struct {
 	Status Status `json:"status"`
-	Record struct {
-		ID     string `json:"id"`
-		Name   string `json:"name"`
-		Value  string `json:"value"`
-		Type   string `json:"type"`
-		Line   string `json:"line"`
-		LineID string `json:"line_id"`
-		TTL    string `json:"ttl"`
-		Status string `json:"status"`
-	} `json:"record"`
+	Record Record `json:"record"`
 }
 
 type RecordModifyResponse struct {
 	Status Status `json:"status"`
 	Record struct {
-		ID     string `json:"id"`
-		Name   string `json:"name"`
-		Value  string `json:"value"`
-		Status string `json:"status"`
+		ID     FlexInt    `json:"id"`
+		Name   FlexString `json:"name"`
+		Value  FlexString `json:"value"`
+		Status FlexString `json:"status"`
 	} `json:"record"`
 }
 
@@ -90,21 +132,11 @@ type RecordListParams struct {
 type RecordListResponse struct {
 	Status Status `json:"status"`
 	Info   struct {
-		SubDomains  string `json:"sub_domains"`
-		RecordTotal string `json:"record_total"`
-		RecordsNum  string `json:"records_num"`
+		SubDomains  FlexInt `json:"sub_domains"`
+		RecordTotal FlexInt `json:"record_total"`
+		RecordsNum  FlexInt `json:"records_num"`
 	} `json:"info"`
-	Records []struct {
-		ID     string `json:"id"`
-		Name   string `json:"name"`
-		Line   string `json:"line"`
-		LineID string `json:"line_id"`
-		Type   string `json:"type"`
-		TTL    string `json:"ttl"`
-		Value  string `json:"value"`
-		MX     string `json:"mx"`
-		Status string `json:"status"`
-	} `json:"records"`
+	Records []Record `json:"records"`
 }
 
 func (c *Client) RecordInfo(ctx context.Context, req CommonRequest, recordID int) (RecordInfoResponse, error) {
@@ -116,7 +148,7 @@ func (c *Client) RecordInfo(ctx context.Context, req CommonRequest, recordID int
 		return RecordInfoResponse{}, err
 	}
 	if out.Status.Code != "1" {
-		return RecordInfoResponse{}, apiError(out.Status)
+		return RecordInfoResponse{}, apiError("Record.Info", out.Status)
 	}
 	return out, nil
 }
@@ -149,7 +181,7 @@ func (c *Client) RecordList(ctx context.Context, req CommonRequest, p RecordList
 		return RecordListResponse{}, err
 	}
 	if out.Status.Code != "1" {
-		return RecordListResponse{}, apiError(out.Status)
+		return RecordListResponse{}, apiError("Record.List", out.Status)
 	}
 	return out, nil
 }
@@ -192,39 +224,321 @@ func (c *Client) RecordModify(ctx context.Context, req CommonRequest, recordID i
 		form.Set("weight", strconv.Itoa(*p.Weight))
 	}
 
-	body, httpStatus, err := c.postFormBytes(ctx, "/Record.Modify", form)
+	// A Modify whose outcome is unknown is only retried after Record.Info
+	// shows it was not applied; a blind retry could count as a no-change
+	// modification and lock the record.
+	verify := func(ctx context.Context) (bool, error) {
+		info, err := c.RecordInfo(ctx, req, recordID)
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(info.Record.Value.String()) == p.Value, nil
+	}
+	body, httpStatus, err := c.send(ctx, "/Record.Modify", form, verify)
+	if errors.Is(err, errAlreadyApplied) {
+		var out RecordModifyResponse
+		out.Status = Status{Code: "1", Message: "applied (verified with Record.Info after retry)"}
+		out.Record.ID = FlexInt(recordID)
+		out.Record.Name = FlexString(p.SubDomain)
+		out.Record.Value = FlexString(p.Value)
+		return out, nil
+	}
 	if err != nil {
 		return RecordModifyResponse{}, err
 	}
 	if httpStatus < 200 || httpStatus >= 300 {
-		return RecordModifyResponse{}, fmt.Errorf("dnspod http %d: %s", httpStatus, truncate(string(body), 512))
+		return RecordModifyResponse{}, &HTTPError{StatusCode: httpStatus, Body: errorBody(body, form)}
 	}
 
 	var out RecordModifyResponse
 	if err := json.Unmarshal(body, &out); err == nil {
 		if out.Status.Code != "1" {
-			return RecordModifyResponse{}, apiError(out.Status)
+			return RecordModifyResponse{}, apiError("Record.Modify", out.Status)
 		}
 		return out, nil
 	}
 
-	// Fallback: some fields can be returned as number/string inconsistently.
-	// If status.code indicates success, treat it as success even if record payload can't be decoded.
+	// Fallback: the write may have been applied even if a record field has
+	// a shape we do not know. Trust status.code so a completed write is not
+	// reported as failed and retried.
 	var statusOnly struct {
 		Status Status `json:"status"`
 	}
-	if err2 := json.Unmarshal(body, &statusOnly); err2 == nil {
-		if statusOnly.Status.Code == "1" {
-			return RecordModifyResponse{Status: statusOnly.Status}, nil
+	if err := json.Unmarshal(body, &statusOnly); err != nil {
+		return RecordModifyResponse{}, fmt.Errorf("decode response: %w (body=%s)", err, errorBody(body, form))
+	}
+	if statusOnly.Status.Code != "1" {
+		return RecordModifyResponse{}, apiError("Record.Modify", statusOnly.Status)
+	}
+	return RecordModifyResponse{Status: statusOnly.Status}, nil
+}
+
+type RecordStatusResponse struct {
+	Status Status `json:"status"`
+	Record struct {
+		ID     FlexInt    `json:"id"`
+		Name   FlexString `json:"name"`
+		Status FlexString `json:"status"`
+	} `json:"record"`
+}
+
+// RecordStatus enables or disables a record. status is "enable" or "disable".
+func (c *Client) RecordStatus(ctx context.Context, req CommonRequest, recordID int, status string) (RecordStatusResponse, error) {
+	form := req.toForm()
+	form.Set("record_id", strconv.Itoa(recordID))
+	form.Set("status", status)
+
+	var out RecordStatusResponse
+	if err := c.postForm(ctx, "/Record.Status", form, &out); err != nil {
+		return RecordStatusResponse{}, err
+	}
+	if out.Status.Code != "1" {
+		return RecordStatusResponse{}, apiError("Record.Status", out.Status)
+	}
+	return out, nil
+}
+
+// RecordRemove deletes a record.
+func (c *Client) RecordRemove(ctx context.Context, req CommonRequest, recordID int) error {
+	form := req.toForm()
+	form.Set("record_id", strconv.Itoa(recordID))
+
+	// A retried Remove that was applied the first time would fail with
+	// "record not found", so check first.
+	verify := func(ctx context.Context) (bool, error) {
+		_, err := c.RecordInfo(ctx, req, recordID)
+		if KindOf(err) == KindRecordNotFound {
+			return true, nil
 		}
-		return RecordModifyResponse{}, apiError(statusOnly.Status)
+		return false, err
+	}
+	body, httpStatus, err := c.send(ctx, "/Record.Remove", form, verify)
+	if errors.Is(err, errAlreadyApplied) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
+	if httpStatus < 200 || httpStatus >= 300 {
+		return &HTTPError{StatusCode: httpStatus, Body: errorBody(body, form)}
+	}
+	var out struct {
+		Status Status `json:"status"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return fmt.Errorf("decode response: %w (body=%s)", err, errorBody(body, form))
+	}
+	if out.Status.Code != "1" {
+		return apiError("Record.Remove", out.Status)
+	}
+	return nil
+}
+
+type DomainListParams struct {
+	// Type filters the domains: "all" (default), "mine", "share", "ismark",
+	// "pause", "vip", "recent" or "share_out".
+	Type    string
+	Offset  int
+	Length  int
+	GroupID string
+	Keyword string
+}
+
+type DomainListResponse struct {
+	Status Status `json:"status"`
+	Info   struct {
+		DomainTotal FlexInt `json:"domain_total"`
+		AllTotal    FlexInt `json:"all_total"`
+		MineTotal   FlexInt `json:"mine_total"`
+	} `json:"info"`
+	Domains []struct {
+		ID        FlexInt    `json:"id"`
+		Name      FlexString `json:"name"`
+		Punycode  FlexString `json:"punycode"`
+		Grade     FlexString `json:"grade"`
+		Status    FlexString `json:"status"`
+		ExtStatus FlexString `json:"ext_status"`
+		Records   FlexInt    `json:"records"`
+		TTL       FlexInt    `json:"ttl"`
+		Remark    FlexString `json:"remark"`
+		UpdatedOn FlexString `json:"updated_on"`
+	} `json:"domains"`
+}
+
+// DomainList lists the domains of the account. The domain fields of req are
+// ignored. An account without domains yields an empty list.
+func (c *Client) DomainList(ctx context.Context, req CommonRequest, p DomainListParams) (DomainListResponse, error) {
+	req.Domain, req.DomainID = "", 0
+	form := req.toForm()
+	if p.Type != "" {
+		form.Set("type", p.Type)
+	}
+	if p.Offset > 0 {
+		form.Set("offset", strconv.Itoa(p.Offset))
+	}
+	if p.Length > 0 {
+		form.Set("length", strconv.Itoa(p.Length))
+	}
+	if p.GroupID != "" {
+		form.Set("group_id", p.GroupID)
+	}
+	if p.Keyword != "" {
+		form.Set("keyword", p.Keyword)
+	}
+
+	var out DomainListResponse
+	if err := c.postForm(ctx, "/Domain.List", form, &out); err != nil {
+		return DomainListResponse{}, err
+	}
+	switch out.Status.Code {
+	case "1":
+	case "9": // 没有任何域名
+		out.Domains = nil
+	default:
+		return DomainListResponse{}, apiError("Domain.List", out.Status)
+	}
+	return out, nil
+}
 
-	return RecordModifyResponse{}, fmt.Errorf("decode response: %w (body=%s)", err, truncate(string(body), 512))
+type DomainInfoResponse struct {
+	Status Status `json:"status"`
+	Domain struct {
+		ID         FlexInt    `json:"id"`
+		Name       FlexString `json:"name"`
+		Punycode   FlexString `json:"punycode"`
+		Grade      FlexString `json:"grade"`
+		GradeTitle FlexString `json:"grade_title"`
+		Status     FlexString `json:"status"`
+		ExtStatus  FlexString `json:"ext_status"`
+		Records    FlexInt    `json:"records"`
+		TTL        FlexInt    `json:"ttl"`
+		Owner      FlexString `json:"owner"`
+		Remark     FlexString `json:"remark"`
+		CreatedOn  FlexString `json:"created_on"`
+		UpdatedOn  FlexString `json:"updated_on"`
+	} `json:"domain"`
+}
+
+// DomainInfo returns the domain named by req.
+func (c *Client) DomainInfo(ctx context.Context, req CommonRequest) (DomainInfoResponse, error) {
+	var out DomainInfoResponse
+	if err := c.postForm(ctx, "/Domain.Info", req.toForm(), &out); err != nil {
+		return DomainInfoResponse{}, err
+	}
+	if out.Status.Code != "1" {
+		return DomainInfoResponse{}, apiError("Domain.Info", out.Status)
+	}
+	return out, nil
+}
+
+type UserDetailResponse struct {
+	Status Status `json:"status"`
+	Info   struct {
+		User struct {
+			ID        FlexInt    `json:"id"`
+			Nick      FlexString `json:"nick"`
+			RealName  FlexString `json:"real_name"`
+			Email     FlexString `json:"email"`
+			Status    FlexString `json:"status"`
+			UserType  FlexString `json:"user_type"`
+			UserGrade FlexString `json:"user_grade"`
+		} `json:"user"`
+	} `json:"info"`
+}
+
+// UserDetail returns the account that owns the token. The domain fields of
+// req are ignored. It is the cheapest way to check a token.
+func (c *Client) UserDetail(ctx context.Context, req CommonRequest) (UserDetailResponse, error) {
+	req.Domain, req.DomainID = "", 0
+
+	var out UserDetailResponse
+	if err := c.postForm(ctx, "/User.Detail", req.toForm(), &out); err != nil {
+		return UserDetailResponse{}, err
+	}
+	if out.Status.Code != "1" {
+		return UserDetailResponse{}, apiError("User.Detail", out.Status)
+	}
+	return out, nil
+}
+
+type RecordCreateResponse struct {
+	Status Status `json:"status"`
+	Record struct {
+		ID     FlexInt    `json:"id"`
+		Name   FlexString `json:"name"`
+		Status FlexString `json:"status"`
+	} `json:"record"`
+}
+
+// RecordCreate adds a record. It is not retried after a failure whose
+// outcome is unknown, because that could create a duplicate.
+func (c *Client) RecordCreate(ctx context.Context, req CommonRequest, p ModifyRecordParams) (RecordCreateResponse, error) {
+	form := req.toForm()
+	if p.SubDomain != "" {
+		form.Set("sub_domain", p.SubDomain)
+	}
+	form.Set("record_type", strings.ToUpper(p.RecordType))
+	if p.RecordLineID != "" {
+		form.Set("record_line_id", p.RecordLineID)
+	} else {
+		form.Set("record_line", p.RecordLine)
+	}
+	form.Set("value", p.Value)
+	if strings.ToUpper(p.RecordType) == "MX" {
+		form.Set("mx", strconv.Itoa(p.MX))
+	}
+	if p.TTL > 0 {
+		form.Set("ttl", strconv.Itoa(p.TTL))
+	}
+	if p.Status != "" {
+		form.Set("status", p.Status)
+	}
+	if p.Weight != nil {
+		form.Set("weight", strconv.Itoa(*p.Weight))
+	}
+
+	body, httpStatus, err := c.send(ctx, "/Record.Create", form, noVerify)
+	if err != nil {
+		return RecordCreateResponse{}, err
+	}
+	if httpStatus < 200 || httpStatus >= 300 {
+		return RecordCreateResponse{}, &HTTPError{StatusCode: httpStatus, Body: errorBody(body, form)}
+	}
+	var out RecordCreateResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return RecordCreateResponse{}, fmt.Errorf("decode response: %w (body=%s)", err, errorBody(body, form))
+	}
+	if out.Status.Code != "1" {
+		return RecordCreateResponse{}, apiError("Record.Create", out.Status)
+	}
+	return out, nil
+}
+
+type RecordLineResponse struct {
+	Status Status       `json:"status"`
+	Lines  []FlexString `json:"lines"`
+	// LineIDs maps line names to ids.
+	LineIDs map[string]FlexString `json:"line_ids"`
+}
+
+// RecordLine lists the lines available for records of the domain. grade is
+// the domain grade, e.g. "DP_Free", as returned by Domain.List.
+func (c *Client) RecordLine(ctx context.Context, req CommonRequest, grade string) (RecordLineResponse, error) {
+	form := req.toForm()
+	form.Set("domain_grade", grade)
+
+	var out RecordLineResponse
+	if err := c.postForm(ctx, "/Record.Line", form, &out); err != nil {
+		return RecordLineResponse{}, err
+	}
+	if out.Status.Code != "1" {
+		return RecordLineResponse{}, apiError("Record.Line", out.Status)
+	}
+	return out, nil
 }
 
 type CommonRequest struct {
-	LoginToken   string
+	LoginToken   secret.String
 	Format       string
 	Lang         string
 	ErrorOnEmpty string
@@ -235,7 +549,7 @@ type CommonRequest struct {
 
 func (r CommonRequest) toForm() url.Values {
 	v := url.Values{}
-	v.Set("login_token", r.LoginToken)
+	v.Set("login_token", r.LoginToken.Reveal())
 	if r.Format != "" {
 		v.Set("format", r.Format)
 	}
@@ -247,59 +561,97 @@ func (r CommonRequest) toForm() url.Values {
 	}
 	if r.DomainID != 0 {
 		v.Set("domain_id", strconv.Itoa(r.DomainID))
-	} else {
+	} else if r.Domain != "" {
 		v.Set("domain", r.Domain)
 	}
 	return v
 }
 
-type APIError struct {
-	Code    string
-	Message string
-}
-
-func (e *APIError) Error() string {
-	return fmt.Sprintf("dnspod api error code=%s message=%s", e.Code, e.Message)
-}
-
-func apiError(s Status) error {
-	return &APIError{Code: s.Code, Message: s.Message}
-}
-
 func (c *Client) postForm(ctx context.Context, path string, form url.Values, out any) error {
 	body, status, err := c.postFormBytes(ctx, path, form)
 	if err != nil {
 		return err
 	}
 	if status < 200 || status >= 300 {
-		return fmt.Errorf("dnspod http %d: %s", status, truncate(string(body), 512))
+		return &HTTPError{StatusCode: status, Body: errorBody(body, form)}
 	}
 	if err := json.Unmarshal(body, out); err != nil {
-		return fmt.Errorf("decode response: %w (body=%s)", err, truncate(string(body), 512))
+		return fmt.Errorf("decode response: %w (body=%s)", err, errorBody(body, form))
 	}
 	return nil
 }
 
 func (c *Client) postFormBytes(ctx context.Context, path string, form url.Values) ([]byte, int, error) {
+	return c.send(ctx, path, form, nil)
+}
+
+// postOnce makes a single HTTP attempt.
+func (c *Client) postOnce(ctx context.Context, path string, form url.Values) ([]byte, int, http.Header, error) {
 	u := c.baseURL + path
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("User-Agent", c.userAgent)
 
 	resp, err := c.hc.Do(req)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, resp.StatusCode, err
+		return nil, resp.StatusCode, resp.Header, err
+	}
+	if c.trace {
+		c.log.Info("dnspod trace", "endpoint", strings.TrimPrefix(path, "/"), "request", redactForm(form), "http_status", resp.StatusCode, "response", errorBody(b, form))
+	}
+	return b, resp.StatusCode, resp.Header, nil
+}
+
+// observe records an attempt in the API metrics and the debug log, labeled
+// with the DNSPod status code, "http_<status>" for non-2xx responses or "error".
+func (c *Client) observe(path string, start time.Time, body []byte, status int, err error) {
+	endpoint := strings.TrimPrefix(path, "/")
+	d := time.Since(start)
+	metrics.APIDuration.Observe(d.Seconds(), endpoint)
+	code := "error"
+	switch {
+	case err != nil:
+	case status < 200 || status >= 300:
+		code = "http_" + strconv.Itoa(status)
+	default:
+		var st struct {
+			Status Status `json:"status"`
+		}
+		if json.Unmarshal(body, &st) == nil && st.Status.Code != "" {
+			code = st.Status.Code.String()
+		}
+	}
+	metrics.APIRequests.Inc(endpoint, code)
+	c.log.Debug("dnspod request", "endpoint", endpoint, "duration", d, "http_status", status, "error_code", code)
+}
+
+// errorBody prepares a response body for an error message: the token is
+// redacted in case DNSPod echoes it back, and the body is truncated.
+func errorBody(body []byte, form url.Values) string {
+	token := secret.New(form.Get("login_token"))
+	return truncate(token.Redact(string(body)), 512)
+}
+
+// redactForm returns form encoded for logging, with credentials replaced.
+func redactForm(form url.Values) string {
+	out := url.Values{}
+	for k, v := range form {
+		if k == "login_token" {
+			v = []string{"***"}
+		}
+		out[k] = v
 	}
-	return b, resp.StatusCode, nil
+	s, _ := url.QueryUnescape(out.Encode())
+	return s
 }
 
 func truncate(s string, n int) string {
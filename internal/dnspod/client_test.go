@@ -0,0 +1,432 @@
+package dnspod_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod/dnspodtest"
+	"github.com/hnrobert/dnspod-updater/internal/secret"
+)
+
+const token = "1,secret"
+
+// newTestClient returns a fake server with example.com and a www A record,
+// and a client pointed at it.
+func newTestClient(t *testing.T) (*dnspodtest.Server, *dnspod.Client, dnspod.CommonRequest, int) {
+	t.Helper()
+	srv := dnspodtest.NewServer(token)
+	t.Cleanup(srv.Close)
+	srv.AddDomain("example.com", "DP_Free")
+	id := srv.AddRecord("example.com", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1"})
+
+	client := dnspod.NewClient(dnspod.ClientOptions{
+		BaseURL:        srv.URL,
+		Retries:        2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  5 * time.Millisecond,
+	})
+	req := dnspod.CommonRequest{LoginToken: secret.New(token), Format: "json", Domain: "example.com"}
+	return srv, client, req, id
+}
+
+func modifyParams(value string) dnspod.ModifyRecordParams {
+	return dnspod.ModifyRecordParams{SubDomain: "www", RecordType: "A", RecordLine: "默认", Value: value}
+}
+
+func TestRecordInfoAndList(t *testing.T) {
+	_, client, req, id := newTestClient(t)
+	ctx := context.Background()
+
+	info, err := client.RecordInfo(ctx, req, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(info.Record.ID) != id || info.Record.Value != "192.0.2.1" || info.Record.TTL != 600 || !bool(info.Record.Enabled) {
+		t.Errorf("RecordInfo = %+v", info.Record)
+	}
+	if info.Record.Weight != nil {
+		t.Errorf("Weight = %v, want nil", *info.Record.Weight)
+	}
+
+	list, err := client.RecordList(ctx, req, dnspod.RecordListParams{SubDomain: "www", RecordType: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Records) != 1 || int(list.Records[0].ID) != id || list.Info.RecordTotal != 1 {
+		t.Errorf("RecordList = %+v", list)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	_, client, req, id := newTestClient(t)
+	ctx := context.Background()
+
+	bad := req
+	bad.LoginToken = secret.New("1,wrong")
+	missingDomain := req
+	missingDomain.Domain = "example.org"
+
+	tests := []struct {
+		name string
+		call func() error
+		want dnspod.ErrorKind
+	}{
+		{"bad token", func() error { _, err := client.RecordInfo(ctx, bad, id); return err }, dnspod.KindAuth},
+		{"unknown domain", func() error { _, err := client.DomainInfo(ctx, missingDomain); return err }, dnspod.KindDomainNotFound},
+		{"unknown record", func() error { _, err := client.RecordInfo(ctx, req, id+1); return err }, dnspod.KindRecordNotFound},
+		{"empty list", func() error {
+			_, err := client.RecordList(ctx, req, dnspod.RecordListParams{SubDomain: "nope"})
+			return err
+		}, dnspod.KindRecordNotFound},
+		{"bad value", func() error { _, err := client.RecordModify(ctx, req, id, modifyParams("300.0.0.1")); return err }, dnspod.KindInvalidParams},
+		{"bad line", func() error {
+			p := modifyParams("192.0.2.2")
+			p.RecordLineID = "99"
+			_, err := client.RecordModify(ctx, req, id, p)
+			return err
+		}, dnspod.KindInvalidParams},
+		{"ttl too small", func() error {
+			p := modifyParams("192.0.2.2")
+			p.TTL = 60
+			_, err := client.RecordModify(ctx, req, id, p)
+			return err
+		}, dnspod.KindInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if got := dnspod.KindOf(err); got != tt.want {
+				t.Errorf("KindOf(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocked(t *testing.T) {
+	tests := []struct {
+		code, message string
+		locked        bool
+	}{
+		{"21", "域名被锁定", true},
+		{"", "record is locked", true},
+		{"-2", "API使用超出限制", false},
+		{"-2", "API usage limit exceeded, account locked", false},
+		{"-8", "登录失败次数过多，帐号被暂时封禁", false},
+	}
+	for _, tt := range tests {
+		err := &dnspod.APIError{Endpoint: "Record.Modify", Code: tt.code, Message: tt.message}
+		if got := err.Locked(); got != tt.locked {
+			t.Errorf("Locked(%s %q) = %v, want %v", tt.code, tt.message, got, tt.locked)
+		}
+		if got := dnspod.KindOf(err); got != dnspod.KindRateLimited {
+			t.Errorf("KindOf(%s %q) = %v, want rate_limited", tt.code, tt.message, got)
+		}
+	}
+}
+
+func TestRecordModify(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+
+	res, err := client.RecordModify(context.Background(), req, id, modifyParams("192.0.2.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(res.Record.ID) != id || res.Record.Value != "192.0.2.2" {
+		t.Errorf("RecordModify = %+v", res.Record)
+	}
+	if r, _ := srv.Record(id); r.Value != "192.0.2.2" {
+		t.Errorf("stored value = %q", r.Value)
+	}
+}
+
+// A Modify that DNSPod applied must not fail because a record field has an
+// unexpected shape.
+func TestRecordModifyUndecodableRecord(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"status":{"code":"1","message":"Action completed successful"},"record":{"id":{"v":1},"name":["www"],"value":"192.0.2.2"}}`)
+	}))
+	defer srv.Close()
+	client := dnspod.NewClient(dnspod.ClientOptions{BaseURL: srv.URL, Retries: 2, RetryBaseDelay: time.Millisecond})
+	req := dnspod.CommonRequest{LoginToken: secret.New(token), Format: "json", Domain: "example.com"}
+
+	res, err := client.RecordModify(context.Background(), req, 1, modifyParams("192.0.2.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status.Code != "1" {
+		t.Errorf("status = %+v", res.Status)
+	}
+	if calls != 1 {
+		t.Errorf("Record.Modify called %d times, want 1", calls)
+	}
+}
+
+func TestFlexBool(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{`"1"`, true}, {`1`, true}, {`true`, true}, {`"enabled"`, true}, {`"enable"`, true},
+		{`"0"`, false}, {`0`, false}, {`"disabled"`, false}, {`""`, false}, {`null`, false},
+		{`"paused"`, false}, {`{"v":1}`, false}, {`[1]`, false},
+	}
+	for _, tt := range tests {
+		var v struct{ B dnspod.FlexBool }
+		if err := json.Unmarshal([]byte(`{"B":`+tt.in+`}`), &v); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+		if bool(v.B) != tt.want {
+			t.Errorf("FlexBool(%s) = %v, want %v", tt.in, v.B, tt.want)
+		}
+	}
+}
+
+// A Modify whose response is lost is checked with Record.Info instead of
+// being sent again.
+func TestRecordModifyVerifiesLostResponse(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	srv.Inject("Record.Modify", dnspodtest.Fault{HTTPStatus: 502, Applied: true})
+
+	if _, err := client.RecordModify(context.Background(), req, id, modifyParams("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Calls("Record.Modify"); n != 1 {
+		t.Errorf("Record.Modify called %d times, want 1", n)
+	}
+	if n := srv.Calls("Record.Info"); n != 1 {
+		t.Errorf("Record.Info called %d times, want 1", n)
+	}
+}
+
+func TestRecordModifyRetriesWhenNotApplied(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	srv.Inject("Record.Modify", dnspodtest.Fault{HTTPStatus: 503, RetryAfter: "0"})
+
+	if _, err := client.RecordModify(context.Background(), req, id, modifyParams("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Calls("Record.Modify"); n != 2 {
+		t.Errorf("Record.Modify called %d times, want 2", n)
+	}
+	if r, _ := srv.Record(id); r.Value != "192.0.2.2" {
+		t.Errorf("stored value = %q", r.Value)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	srv.Inject("Record.Info", dnspodtest.Fault{HTTPStatus: 429, RetryAfter: "3600"})
+
+	_, err := client.RecordInfo(context.Background(), req, id)
+	if k := dnspod.KindOf(err); k != dnspod.KindRateLimited {
+		t.Fatalf("KindOf(%v) = %v, want rate_limited", err, k)
+	}
+	if n := srv.Calls("Record.Info"); n != 1 {
+		t.Errorf("Record.Info called %d times, want 1", n)
+	}
+}
+
+func TestRetriesHangup(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	srv.Inject("Record.Info", dnspodtest.Fault{Hangup: true})
+
+	if _, err := client.RecordInfo(context.Background(), req, id); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Calls("Record.Info"); n != 2 {
+		t.Errorf("Record.Info called %d times, want 2", n)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	f := dnspodtest.Fault{HTTPStatus: 500}
+	srv.Inject("Record.Info", f, f, f)
+
+	_, err := client.RecordInfo(context.Background(), req, id)
+	var httpErr *dnspod.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 500 {
+		t.Fatalf("err = %v, want HTTP 500", err)
+	}
+	if dnspod.KindOf(err) != dnspod.KindTransient {
+		t.Errorf("KindOf = %v, want transient", dnspod.KindOf(err))
+	}
+	if n := srv.Calls("Record.Info"); n != 3 {
+		t.Errorf("Record.Info called %d times, want 3", n)
+	}
+}
+
+func TestRecordCreateIsNotRetried(t *testing.T) {
+	srv, client, req, _ := newTestClient(t)
+	srv.Inject("Record.Create", dnspodtest.Fault{HTTPStatus: 502, Applied: true})
+
+	p := modifyParams("192.0.2.9")
+	p.SubDomain = "new"
+	if _, err := client.RecordCreate(context.Background(), req, p); err == nil {
+		t.Fatal("no error")
+	}
+	if n := srv.Calls("Record.Create"); n != 1 {
+		t.Errorf("Record.Create called %d times, want 1", n)
+	}
+}
+
+func TestRecordCreateAndRemove(t *testing.T) {
+	srv, client, req, _ := newTestClient(t)
+	ctx := context.Background()
+
+	p := modifyParams("192.0.2.9")
+	p.SubDomain = "new"
+	res, err := client.RecordCreate(ctx, req, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := int(res.Record.ID)
+	if r, ok := srv.Record(id); !ok || r.Name != "new" || r.Value != "192.0.2.9" {
+		t.Fatalf("created record = %+v, %v", r, ok)
+	}
+
+	_, err = client.RecordCreate(ctx, req, p)
+	if err == nil {
+		t.Error("duplicate create succeeded")
+	}
+
+	// The first response is lost; the retry must not fail with "not found".
+	srv.Inject("Record.Remove", dnspodtest.Fault{HTTPStatus: 502, Applied: true})
+	if err := client.RecordRemove(ctx, req, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Record(id); ok {
+		t.Error("record still exists")
+	}
+	if err := client.RecordRemove(ctx, req, id); dnspod.KindOf(err) != dnspod.KindRecordNotFound {
+		t.Errorf("second remove: %v", err)
+	}
+}
+
+func TestRecordStatus(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+
+	ctx := context.Background()
+
+	resp, err := client.RecordStatus(ctx, req, id, "disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := srv.Record(id); r.Status != "disable" {
+		t.Errorf("status = %q", r.Status)
+	}
+	// Responses report the state as DNSPod does, not as the request parameter.
+	if got := resp.Record.Status.String(); got != "disabled" {
+		t.Errorf("Record.Status status = %q, want disabled", got)
+	}
+	info, err := client.RecordInfo(ctx, req, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bool(info.Record.Enabled) || info.Record.Status.String() != "disabled" {
+		t.Errorf("Record.Info enabled = %v, status = %q", info.Record.Enabled, info.Record.Status)
+	}
+}
+
+func TestNoChangeModificationsLock(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < dnspodtest.LockAfter; i++ {
+		if _, err := client.RecordModify(ctx, req, id, modifyParams("192.0.2.1")); err != nil {
+			t.Fatalf("modify %d: %v", i+1, err)
+		}
+	}
+	_, err := client.RecordModify(ctx, req, id, modifyParams("192.0.2.1"))
+	var apiErr *dnspod.APIError
+	if !errors.As(err, &apiErr) || !apiErr.Locked() {
+		t.Fatalf("err = %v, want a lock", err)
+	}
+	if dnspod.KindOf(err) != dnspod.KindRateLimited {
+		t.Errorf("KindOf = %v, want rate_limited", dnspod.KindOf(err))
+	}
+	if srv.LockedUntil(id).IsZero() {
+		t.Error("record not locked")
+	}
+	// Real changes are refused too while the lock lasts.
+	if _, err := client.RecordModify(ctx, req, id, modifyParams("192.0.2.2")); dnspod.KindOf(err) != dnspod.KindRateLimited {
+		t.Errorf("modify while locked: %v", err)
+	}
+
+	srv.Now = func() time.Time { return time.Now().Add(dnspodtest.LockDuration + time.Minute) }
+	if _, err := client.RecordModify(ctx, req, id, modifyParams("192.0.2.2")); err != nil {
+		t.Errorf("modify after the lock: %v", err)
+	}
+}
+
+func TestInjectedStatusCode(t *testing.T) {
+	srv, client, req, id := newTestClient(t)
+	srv.Inject("Record.Info", dnspodtest.Fault{Code: "-2", Message: "API 使用超出限制"})
+
+	_, err := client.RecordInfo(context.Background(), req, id)
+	if dnspod.KindOf(err) != dnspod.KindRateLimited {
+		t.Fatalf("err = %v, want rate_limited", err)
+	}
+	// API errors are answers, not failures worth retrying.
+	if n := srv.Calls("Record.Info"); n != 1 {
+		t.Errorf("Record.Info called %d times, want 1", n)
+	}
+}
+
+func TestDomains(t *testing.T) {
+	srv, client, req, _ := newTestClient(t)
+	ctx := context.Background()
+
+	list, err := client.DomainList(ctx, req, dnspod.DomainListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Domains) != 1 || list.Domains[0].Name != "example.com" || list.Domains[0].Records != 1 {
+		t.Errorf("DomainList = %+v", list.Domains)
+	}
+	// Domain.List fails with code 9 when nothing matches.
+	list, err = client.DomainList(ctx, req, dnspod.DomainListParams{Keyword: "nothing"})
+	if err != nil || len(list.Domains) != 0 {
+		t.Errorf("DomainList(nothing) = %+v, %v", list.Domains, err)
+	}
+
+	info, err := client.DomainInfo(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Domain.Grade != "DP_Free" || info.Domain.ID == 0 {
+		t.Errorf("DomainInfo = %+v", info.Domain)
+	}
+
+	lines, err := client.RecordLine(ctx, req, info.Domain.Grade.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines.LineIDs["默认"] != "0" || len(lines.Lines) != len(dnspodtest.Lines) {
+		t.Errorf("RecordLine = %+v", lines)
+	}
+
+	user, err := client.UserDetail(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Info.User.ID != 1 {
+		t.Errorf("UserDetail = %+v", user.Info.User)
+	}
+	if n := len(srv.Requests()); n != 5 {
+		t.Errorf("%d requests, want 5", n)
+	}
+}
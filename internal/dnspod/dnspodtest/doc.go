@@ -0,0 +1,4 @@
+package dnspodtest
+
+// Package dnspodtest provides an in-memory DNSPod API server for tests and
+// local development, built on net/http/httptest.
@@ -0,0 +1,655 @@
+package dnspodtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Grade limits. The lock mirrors DNSPod: more than LockAfter no-change
+// modifications of a record within an hour lock it for an hour.
+const (
+	LockAfter    = 5
+	LockDuration = time.Hour
+)
+
+// Record is a record in the fake zone store.
+type Record struct {
+	ID     int
+	Name   string // sub-domain, "@" for the apex
+	Type   string
+	Line   string
+	LineID string
+	Value  string
+	TTL    int
+	MX     int
+	Weight *int
+	// Status is "enable" or "disable", as in the request parameter.
+	// Responses encode it like DNSPod: enabled "1"/"0" and status
+	// "enabled"/"disabled".
+	Status string
+	Remark string
+
+	UpdatedOn time.Time
+}
+
+// Domain is a domain in the fake zone store.
+type Domain struct {
+	ID    int
+	Name  string
+	Grade string
+}
+
+// Request is a call received by the server. Form excludes login_token.
+type Request struct {
+	Endpoint string
+	Form     url.Values
+}
+
+// Fault makes one call misbehave; see Server.Inject.
+type Fault struct {
+	// HTTPStatus, if non-zero, is returned with a plain text body.
+	HTTPStatus int
+	// RetryAfter sets the Retry-After header of an HTTPStatus response.
+	RetryAfter string
+	// Code and Message, if Code is set, are returned as the DNSPod status.
+	Code    string
+	Message string
+	// Hangup closes the connection without a response.
+	Hangup bool
+	// Applied processes the call before failing, like a response that got
+	// lost on the way back.
+	Applied bool
+}
+
+// Server is a fake DNSPod API. The zero value is not usable; use NewServer.
+type Server struct {
+	*httptest.Server
+
+	// Now is the clock used for locks and updated_on. Defaults to time.Now.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	token    string
+	domains  []*Domain
+	records  map[int][]*Record // by domain id
+	nextID   int
+	faults   map[string][]Fault
+	requests []Request
+	noChange map[int][]time.Time
+	locked   map[int]time.Time
+}
+
+// NewServer starts a server that accepts token ("id,token"). Close it when
+// done.
+func NewServer(token string) *Server {
+	s := NewUnstartedServer(token)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer is like NewServer but does not start the server, so
+// the listener can be replaced first, as with httptest.NewUnstartedServer.
+func NewUnstartedServer(token string) *Server {
+	s := &Server{
+		Now:      time.Now,
+		token:    token,
+		records:  map[int][]*Record{},
+		nextID:   1000,
+		faults:   map[string][]Fault{},
+		noChange: map[int][]time.Time{},
+		locked:   map[int]time.Time{},
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddDomain adds a domain with the given grade, e.g. "DP_Free", and returns
+// its id.
+func (s *Server) AddDomain(name, grade string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.domains = append(s.domains, &Domain{ID: s.nextID, Name: name, Grade: grade})
+	return s.nextID
+}
+
+// AddRecord adds r to the domain and returns its id. Empty Line, Status and
+// TTL get DNSPod's defaults.
+func (s *Server) AddRecord(domain string, r Record) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.domainByName(domain)
+	if d == nil {
+		panic("dnspodtest: unknown domain " + domain)
+	}
+	s.nextID++
+	r.ID = s.nextID
+	if r.Line == "" {
+		r.Line, r.LineID = "默认", "0"
+	}
+	if r.Status == "" {
+		r.Status = "enable"
+	}
+	if r.TTL == 0 {
+		r.TTL = 600
+	}
+	r.UpdatedOn = s.Now()
+	s.records[d.ID] = append(s.records[d.ID], &r)
+	return r.ID
+}
+
+// Record returns a copy of the record with the given id.
+func (s *Server) Record(id int) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, r := s.recordByID(id); r != nil {
+		return *r, true
+	}
+	return Record{}, false
+}
+
+// RemoveRecord deletes a record, as if someone removed it in the console.
+func (s *Server) RemoveRecord(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+// Inject queues faults for endpoint, e.g. "Record.Modify". Each call to the
+// endpoint consumes one fault until the queue is empty.
+func (s *Server) Inject(endpoint string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[endpoint] = append(s.faults[endpoint], faults...)
+}
+
+// Requests returns the calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Calls returns how many times endpoint was called.
+func (s *Server) Calls(endpoint string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Endpoint == endpoint {
+			n++
+		}
+	}
+	return n
+}
+
+// LockedUntil returns when the lock of a record expires, or the zero time.
+func (s *Server) LockedUntil(id int) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.locked[id]; s.Now().Before(t) {
+		return t
+	}
+	return time.Time{}
+}
+
+type handler func(s *Server, form url.Values) (status, any)
+
+var handlers = map[string]handler{
+	"User.Detail":   (*Server).userDetail,
+	"Domain.Info":   (*Server).domainInfo,
+	"Domain.List":   (*Server).domainList,
+	"Record.Line":   (*Server).recordLine,
+	"Record.Info":   (*Server).recordInfo,
+	"Record.List":   (*Server).recordList,
+	"Record.Create": (*Server).recordCreate,
+	"Record.Modify": (*Server).recordModify,
+	"Record.Remove": (*Server).recordRemove,
+	"Record.Status": (*Server).recordStatus,
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := r.PostForm
+	token := form.Get("login_token")
+
+	s.mu.Lock()
+	logged := url.Values{}
+	for k, v := range form {
+		if k != "login_token" {
+			logged[k] = v
+		}
+	}
+	s.requests = append(s.requests, Request{Endpoint: endpoint, Form: logged})
+	var fault *Fault
+	if q := s.faults[endpoint]; len(q) > 0 {
+		fault = &q[0]
+		s.faults[endpoint] = q[1:]
+	}
+	h, ok := handlers[endpoint]
+	var st status
+	var payload any
+	switch {
+	case !ok:
+		st = status{"-99", "此功能暂停开放"}
+	case token != s.token:
+		st = status{"-1", "登录失败"}
+	case fault != nil && !fault.Applied && (fault.HTTPStatus != 0 || fault.Hangup || fault.Code != ""):
+		// Fail without touching the store.
+	default:
+		st, payload = h(s, form)
+	}
+	s.mu.Unlock()
+
+	if fault != nil {
+		switch {
+		case fault.Hangup:
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		case fault.HTTPStatus != 0:
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			http.Error(w, http.StatusText(fault.HTTPStatus), fault.HTTPStatus)
+			return
+		case fault.Code != "":
+			st, payload = status{fault.Code, fault.Message}, nil
+		}
+	}
+	writeJSON(w, st, payload)
+}
+
+type status struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var ok = status{"1", "Action completed successful"}
+
+func writeJSON(w http.ResponseWriter, st status, payload any) {
+	body := map[string]any{"status": map[string]string{
+		"code":       st.Code,
+		"message":    st.Message,
+		"created_at": time.Now().Format("2006-01-02 15:04:05"),
+	}}
+	if m, isMap := payload.(map[string]any); isMap && st.Code == "1" {
+		for k, v := range m {
+			body[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) userDetail(url.Values) (status, any) {
+	return ok, map[string]any{"info": map[string]any{"user": map[string]any{
+		"id": strings.SplitN(s.token, ",", 2)[0], "nick": "dnspodtest", "status": "enabled", "user_grade": "DP_Free",
+	}}}
+}
+
+// domain finds the domain named by domain_id or domain. The status is set
+// when it does not exist.
+func (s *Server) domain(form url.Values) (*Domain, status) {
+	if v := form.Get("domain_id"); v != "" {
+		id, _ := strconv.Atoi(v)
+		for _, d := range s.domains {
+			if d.ID == id {
+				return d, ok
+			}
+		}
+		return nil, status{"6", "域名ID错误"}
+	}
+	if d := s.domainByName(form.Get("domain")); d != nil {
+		return d, ok
+	}
+	return nil, status{"6", "域名ID错误"}
+}
+
+func (s *Server) domainByName(name string) *Domain {
+	for _, d := range s.domains {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func domainJSON(d *Domain, records int) map[string]any {
+	return map[string]any{
+		"id": d.ID, "name": d.Name, "punycode": d.Name, "grade": d.Grade,
+		"status": "enable", "ext_status": "", "records": strconv.Itoa(records), "ttl": "600",
+	}
+}
+
+func (s *Server) domainInfo(form url.Values) (status, any) {
+	d, st := s.domain(form)
+	if d == nil {
+		return st, nil
+	}
+	return ok, map[string]any{"domain": domainJSON(d, len(s.records[d.ID]))}
+}
+
+func (s *Server) domainList(form url.Values) (status, any) {
+	var out []map[string]any
+	for _, d := range s.domains {
+		if kw := form.Get("keyword"); kw != "" && !strings.Contains(d.Name, kw) {
+			continue
+		}
+		out = append(out, domainJSON(d, len(s.records[d.ID])))
+	}
+	if len(out) == 0 {
+		return status{"9", "没有任何域名"}, nil
+	}
+	return ok, map[string]any{
+		"info":    map[string]any{"domain_total": len(out), "all_total": len(out), "mine_total": len(out)},
+		"domains": out,
+	}
+}
+
+// Lines returns the lines available to every grade, by name.
+var Lines = map[string]string{"默认": "0", "电信": "10=0", "联通": "10=1", "移动": "10=3"}
+
+func (s *Server) recordLine(form url.Values) (status, any) {
+	if d, st := s.domain(form); d == nil {
+		return st, nil
+	}
+	names := make([]string, 0, len(Lines))
+	for name := range Lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return ok, map[string]any{"lines": names, "line_ids": Lines}
+}
+
+// recordJSON encodes numbers as strings, like Record.Info and Record.List.
+func recordJSON(r *Record) map[string]any {
+	var weight any
+	if r.Weight != nil {
+		weight = *r.Weight
+	}
+	enabled := "1"
+	if r.Status == "disable" {
+		enabled = "0"
+	}
+	return map[string]any{
+		"id": strconv.Itoa(r.ID), "name": r.Name, "type": r.Type, "line": r.Line, "line_id": r.LineID,
+		"value": r.Value, "ttl": strconv.Itoa(r.TTL), "mx": strconv.Itoa(r.MX), "weight": weight,
+		"status": statusJSON(r), "enabled": enabled, "remark": r.Remark,
+		"updated_on": r.UpdatedOn.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// record finds the record named by record_id within the domain of form.
+func (s *Server) record(form url.Values) (*Domain, *Record, status) {
+	d, st := s.domain(form)
+	if d == nil {
+		return nil, nil, st
+	}
+	id, _ := strconv.Atoi(form.Get("record_id"))
+	for _, r := range s.records[d.ID] {
+		if r.ID == id {
+			return d, r, ok
+		}
+	}
+	return d, nil, status{"8", "记录ID错误"}
+}
+
+func (s *Server) recordByID(id int) (int, *Record) {
+	for did, rs := range s.records {
+		for _, r := range rs {
+			if r.ID == id {
+				return did, r
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (s *Server) remove(id int) {
+	did, _ := s.recordByID(id)
+	rs := s.records[did]
+	for i, r := range rs {
+		if r.ID == id {
+			s.records[did] = append(rs[:i:i], rs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Server) recordInfo(form url.Values) (status, any) {
+	_, r, st := s.record(form)
+	if r == nil {
+		return st, nil
+	}
+	return ok, map[string]any{"record": recordJSON(r)}
+}
+
+func (s *Server) recordList(form url.Values) (status, any) {
+	d, st := s.domain(form)
+	if d == nil {
+		return st, nil
+	}
+	var out []map[string]any
+	for _, r := range s.records[d.ID] {
+		if sub := form.Get("sub_domain"); sub != "" && r.Name != sub {
+			continue
+		}
+		if typ := form.Get("record_type"); typ != "" && r.Type != typ {
+			continue
+		}
+		if kw := form.Get("keyword"); kw != "" && !strings.Contains(r.Name, kw) && !strings.Contains(r.Value, kw) {
+			continue
+		}
+		out = append(out, recordJSON(r))
+	}
+	if len(out) == 0 {
+		return status{"10", "记录列表为空"}, nil
+	}
+	n := strconv.Itoa(len(out))
+	return ok, map[string]any{
+		"domain":  domainJSON(d, len(s.records[d.ID])),
+		"info":    map[string]any{"sub_domains": n, "record_total": n, "records_num": n},
+		"records": out,
+	}
+}
+
+// minTTL mirrors dnspod.MinTTL for the grades the fake knows.
+func minTTL(grade string) int {
+	switch strings.TrimPrefix(strings.TrimPrefix(grade, "DP_"), "D_") {
+	case "Plus":
+		return 120
+	case "Extra":
+		return 60
+	case "Expert", "Ultra":
+		return 1
+	default:
+		return 600
+	}
+}
+
+// validate checks the record fields of a Create or Modify and applies them
+// to r.
+func (s *Server) validate(d *Domain, r *Record, form url.Values) status {
+	if v := form.Get("sub_domain"); v != "" {
+		r.Name = v
+	}
+	if r.Name == "" {
+		r.Name = "@"
+	}
+	switch typ := form.Get("record_type"); typ {
+	case "A", "AAAA", "CNAME", "MX", "TXT", "NS", "SRV", "CAA":
+		r.Type = typ
+	default:
+		return status{"27", "记录类型错误"}
+	}
+	if id := form.Get("record_line_id"); id != "" {
+		r.Line = ""
+		for name, lid := range Lines {
+			if lid == id {
+				r.Line, r.LineID = name, lid
+			}
+		}
+		if r.Line == "" {
+			return status{"26", "记录线路错误"}
+		}
+	} else {
+		lid, known := Lines[form.Get("record_line")]
+		if !known {
+			return status{"26", "记录线路错误"}
+		}
+		r.Line, r.LineID = form.Get("record_line"), lid
+	}
+	r.Value = form.Get("value")
+	if r.Type == "A" {
+		if ip := parseIPv4(r.Value); ip == "" {
+			return status{"34", "记录值非法"}
+		}
+	}
+	if r.Type == "MX" {
+		mx, err := strconv.Atoi(form.Get("mx"))
+		if err != nil || mx < 1 || mx > 20 {
+			return status{"30", "MX 值错误，1-20"}
+		}
+		r.MX = mx
+	}
+	if v := form.Get("ttl"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil || ttl < minTTL(d.Grade) || ttl > 604800 {
+			return status{"29", "TTL 值太小"}
+		}
+		r.TTL = ttl
+	}
+	if v := form.Get("status"); v != "" {
+		if v != "enable" && v != "disable" {
+			return status{"2", "参数错误"}
+		}
+		r.Status = v
+	}
+	if v := form.Get("weight"); v != "" {
+		w, err := strconv.Atoi(v)
+		if err != nil || w < 0 || w > 100 {
+			return status{"2", "参数错误"}
+		}
+		r.Weight = &w
+	}
+	return ok
+}
+
+func parseIPv4(s string) string {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return ""
+	}
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 || strconv.Itoa(n) != p {
+			return ""
+		}
+	}
+	return s
+}
+
+func (s *Server) recordCreate(form url.Values) (status, any) {
+	d, st := s.domain(form)
+	if d == nil {
+		return st, nil
+	}
+	r := Record{Status: "enable", TTL: 600}
+	if st := s.validate(d, &r, form); st != ok {
+		return st, nil
+	}
+	for _, o := range s.records[d.ID] {
+		if o.Name == r.Name && o.Type == r.Type && o.Line == r.Line && o.Value == r.Value {
+			return status{"104", "记录已经存在，无需再次添加"}, nil
+		}
+	}
+	s.nextID++
+	r.ID = s.nextID
+	r.UpdatedOn = s.Now()
+	s.records[d.ID] = append(s.records[d.ID], &r)
+	// Record.Create returns the id as a number.
+	return ok, map[string]any{"record": map[string]any{"id": r.ID, "name": r.Name, "status": statusJSON(&r)}}
+}
+
+func (s *Server) recordModify(form url.Values) (status, any) {
+	d, r, st := s.record(form)
+	if r == nil {
+		return st, nil
+	}
+	now := s.Now()
+	if now.Before(s.locked[r.ID]) {
+		return status{"21", "记录被锁定"}, nil
+	}
+	next := *r
+	if st := s.validate(d, &next, form); st != ok {
+		return st, nil
+	}
+	if next.Value == r.Value && next.Type == r.Type && next.Line == r.Line && next.Name == r.Name {
+		// DNSPod locks records that are "modified" without a change too often.
+		var recent []time.Time
+		for _, t := range s.noChange[r.ID] {
+			if now.Sub(t) < time.Hour {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+		s.noChange[r.ID] = recent
+		if len(recent) > LockAfter {
+			s.locked[r.ID] = now.Add(LockDuration)
+			return status{"21", "记录被锁定"}, nil
+		}
+	}
+	next.UpdatedOn = now
+	*r = next
+	// Record.Modify returns the id as a number.
+	return ok, map[string]any{"record": map[string]any{"id": r.ID, "name": r.Name, "value": r.Value, "status": statusJSON(r)}}
+}
+
+func (s *Server) recordRemove(form url.Values) (status, any) {
+	_, r, st := s.record(form)
+	if r == nil {
+		return st, nil
+	}
+	s.remove(r.ID)
+	return ok, nil
+}
+
+// statusJSON is the status field of record responses.
+func statusJSON(r *Record) string {
+	if r.Status == "disable" {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+func (s *Server) recordStatus(form url.Values) (status, any) {
+	_, r, st := s.record(form)
+	if r == nil {
+		return st, nil
+	}
+	v := form.Get("status")
+	if v != "enable" && v != "disable" {
+		return status{"2", "参数错误"}, nil
+	}
+	r.Status = v
+	r.UpdatedOn = s.Now()
+	return ok, map[string]any{"record": map[string]any{"id": r.ID, "name": r.Name, "status": statusJSON(r)}}
+}
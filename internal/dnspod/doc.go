@@ -3,5 +3,13 @@ package dnspod
 // Minimal DNSPod “传统 API” client.
 //
 // Only implements the endpoints needed for this repo:
+// - Domain.Info
+// - Domain.List
+// - Record.Create
 // - Record.Info
+// - Record.Line
+// - Record.List
 // - Record.Modify
+// - Record.Remove
+// - Record.Status
+// - User.Detail
@@ -0,0 +1,205 @@
+package dnspod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrorKind classifies a failed DNSPod call so callers can decide whether to
+// give up, retry or re-resolve.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	// KindAuth: bad token, no permission on the domain, account restrictions.
+	KindAuth
+	// KindDomainNotFound: the domain (id) does not exist or is banned.
+	KindDomainNotFound
+	// KindRecordNotFound: the record id does not exist (any more).
+	KindRecordNotFound
+	// KindRateLimited: API usage limit exceeded, or the record is locked.
+	KindRateLimited
+	// KindInvalidParams: the request was rejected because of a bad field value.
+	KindInvalidParams
+	// KindTransient: network failure, 5xx or DNSPod-side error worth retrying.
+	KindTransient
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindDomainNotFound:
+		return "domain_not_found"
+	case KindRecordNotFound:
+		return "record_not_found"
+	case KindRateLimited:
+		return "rate_limited"
+	case KindInvalidParams:
+		return "invalid_params"
+	case KindTransient:
+		return "transient"
+	default:
+		return "unknown"
+	}
+}
+
+// Fatal reports whether retrying without a config change cannot help.
+func (k ErrorKind) Fatal() bool {
+	return k == KindAuth || k == KindDomainNotFound || k == KindInvalidParams
+}
+
+// Status codes shared by all endpoints.
+var commonCodes = map[string]ErrorKind{
+	"-1":  KindAuth,        // 登录失败
+	"-2":  KindRateLimited, // API 使用超出限制
+	"-3":  KindAuth,        // 不是合法代理
+	"-4":  KindAuth,        // 不在代理名下
+	"-7":  KindAuth,        // 无权使用此接口
+	"-8":  KindRateLimited, // 登录失败次数过多，帐号被暂时封禁
+	"-15": KindDomainNotFound,
+	"-99": KindTransient, // 此功能暂停开放
+	"2":   KindInvalidParams,
+	"3":   KindTransient, // 未知错误
+	"85":  KindAuth,      // 帐号异地登录，请求被拒绝
+}
+
+// Status codes whose meaning depends on the endpoint.
+var endpointCodes = map[string]map[string]ErrorKind{
+	"Domain.Info": {
+		"6": KindDomainNotFound, // 域名ID错误
+		"8": KindAuth,           // 不是域名所有者
+	},
+	"Domain.List": {
+		"6": KindInvalidParams, // 记录开始的偏移无效
+		"7": KindInvalidParams, // 共要获取的记录的数量无效
+	},
+	"Record.List": {
+		"6":  KindDomainNotFound,
+		"7":  KindInvalidParams, // 记录开始的偏移无效
+		"8":  KindInvalidParams, // 共要获取的记录的数量无效
+		"9":  KindAuth,          // 不是域名所有者
+		"10": KindRecordNotFound,
+	},
+	"Record.Line": {
+		"6": KindDomainNotFound,
+		"7": KindAuth, // 不是域名所有者
+	},
+	"Record.Create": recordCodes,
+	"Record.Info":   recordCodes,
+	"Record.Modify": recordCodes,
+	"Record.Remove": recordCodes,
+	"Record.Status": recordCodes,
+}
+
+var recordCodes = map[string]ErrorKind{
+	"6":      KindDomainNotFound,
+	"7":      KindAuth, // 不是域名所有者或者没有权限
+	"8":      KindRecordNotFound,
+	"21":     KindRateLimited, // 域名被锁定
+	"22":     KindInvalidParams,
+	"23":     KindInvalidParams,
+	"24":     KindInvalidParams,
+	"25":     KindInvalidParams,
+	"26":     KindInvalidParams, // 记录线路错误
+	"27":     KindInvalidParams, // 记录类型错误
+	"29":     KindInvalidParams, // TTL 值太小
+	"30":     KindInvalidParams, // MX 值错误
+	"31":     KindInvalidParams,
+	"32":     KindInvalidParams,
+	"33":     KindInvalidParams,
+	"34":     KindInvalidParams, // 记录值非法
+	"35":     KindInvalidParams, // 添加的 IP 不允许
+	"36":     KindInvalidParams,
+	"82":     KindInvalidParams, // 不能添加黑名单中的 IP
+	"500025": KindInvalidParams,
+	"500026": KindInvalidParams,
+}
+
+type APIError struct {
+	Endpoint string
+	Code     string
+	Message  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("dnspod api error code=%s message=%s", e.Code, e.Message)
+}
+
+// Kind maps the documented status code to an ErrorKind.
+func (e *APIError) Kind() ErrorKind {
+	if k, ok := endpointCodes[e.Endpoint][e.Code]; ok {
+		return k
+	}
+	if k, ok := commonCodes[e.Code]; ok {
+		return k
+	}
+	if e.Locked() {
+		return KindRateLimited
+	}
+	return KindUnknown
+}
+
+// Locked reports whether DNSPod refused the call because the record is
+// temporarily locked, e.g. after more than five no-change modifications
+// within an hour. Account-wide limits such as "-2" (API usage exceeded) are
+// KindRateLimited but not Locked: they say nothing about the record.
+func (e *APIError) Locked() bool {
+	if e.Code == "21" {
+		return true
+	}
+	if _, ok := commonCodes[e.Code]; ok {
+		return false
+	}
+	m := strings.ToLower(e.Message)
+	return strings.Contains(m, "锁定") || strings.Contains(m, "locked")
+}
+
+func apiError(endpoint string, s Status) error {
+	return &APIError{Endpoint: endpoint, Code: s.Code.String(), Message: s.Message.String()}
+}
+
+// HTTPError is returned when DNSPod answers with a non-2xx HTTP status.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("dnspod http %d: %s", e.StatusCode, e.Body)
+}
+
+// KindOf classifies any error returned by Client.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind()
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch {
+		case httpErr.StatusCode == 429:
+			return KindRateLimited
+		case httpErr.StatusCode == 401 || httpErr.StatusCode == 403:
+			return KindAuth
+		case httpErr.StatusCode >= 500:
+			return KindTransient
+		}
+		return KindUnknown
+	}
+	if errors.Is(err, context.Canceled) {
+		return KindUnknown
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return KindTransient
+	}
+	return KindUnknown
+}
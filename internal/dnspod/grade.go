@@ -0,0 +1,21 @@
+package dnspod
+
+import "strings"
+
+// minTTL is the smallest TTL each plan allows, by domain grade without the
+// "D_"/"DP_" prefix.
+var minTTL = map[string]int{
+	"Free":   600, // 免费版
+	"Plus":   120, // 个人专业版
+	"Extra":  60,  // 企业创业版
+	"Expert": 1,   // 企业标准版
+	"Ultra":  1,   // 企业旗舰版
+}
+
+// MinTTL returns the smallest TTL allowed for a domain grade as returned by
+// Domain.Info, e.g. "DP_Free". ok is false for grades it does not know, such
+// as new plans, whose minimum is left for DNSPod to enforce.
+func MinTTL(grade string) (min int, ok bool) {
+	min, ok = minTTL[strings.TrimPrefix(strings.TrimPrefix(grade, "DP_"), "D_")]
+	return min, ok
+}
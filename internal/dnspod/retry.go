@@ -0,0 +1,129 @@
+package dnspod
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errAlreadyApplied is returned by send when a write with an unknown outcome
+// turned out to be applied already.
+var errAlreadyApplied = errors.New("already applied")
+
+type retryPolicy struct {
+	retries int
+	base    time.Duration
+	max     time.Duration
+}
+
+// verifyFunc reports whether a write whose outcome is unknown was applied.
+type verifyFunc func(ctx context.Context) (bool, error)
+
+// noVerify is the verifyFunc of writes that cannot be checked afterwards:
+// an attempt that may have reached DNSPod is not retried.
+func noVerify(context.Context) (bool, error) {
+	return false, errors.New("write cannot be verified")
+}
+
+// send posts form to path, retrying network errors, HTTP 5xx and 429 with
+// exponential backoff and jitter. A Retry-After longer than the maximum
+// backoff or the time left before the deadline ends the retries. For non-idempotent calls verify must be set:
+// it is consulted before retrying an attempt that may have reached DNSPod.
+func (c *Client) send(ctx context.Context, path string, form url.Values, verify verifyFunc) ([]byte, int, error) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		body, status, header, err := c.postOnce(ctx, path, form)
+		c.observe(path, start, body, status, err)
+		if !retryable(status, err) || attempt >= c.retry.retries || ctx.Err() != nil {
+			return body, status, err
+		}
+
+		delay := c.retry.backoff(attempt)
+		if ra := retryAfter(header); ra > delay {
+			if ra > c.retry.max {
+				// Retrying sooner than DNSPod asked would only be refused
+				// again; leave the wait to the caller.
+				return body, status, err
+			}
+			delay = ra
+		}
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < delay {
+			return body, status, err
+		}
+
+		if verify != nil && maybeSent(status, err) {
+			applied, verr := verify(ctx)
+			if verr != nil {
+				// Can't tell what happened; don't risk a duplicate write.
+				return body, status, err
+			}
+			if applied {
+				return nil, 0, errAlreadyApplied
+			}
+		}
+		c.log.Warn("retrying dnspod request", "endpoint", strings.TrimPrefix(path, "/"), "attempt", attempt+1, "delay", delay, "http_status", status, "error", err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return body, status, err
+		case <-t.C:
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt+1: exponential growth
+// capped at max, with jitter in [d/2, d].
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.base << attempt
+	if d <= 0 || d > p.max {
+		d = p.max
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+func retryable(status int, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// maybeSent reports whether a failed attempt may have been processed by
+// DNSPod. Dial and DNS failures, and 429 responses, never reached the API.
+func maybeSent(status int, err error) bool {
+	if err == nil {
+		return status != http.StatusTooManyRequests
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return false
+	}
+	return true
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(h http.Header) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package dnspod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DNSPod is not consistent about JSON types: the same field can be a string
+// in one response and a number in another (ids, ttl, weight, status codes).
+// The Flex types accept both, so one odd field doesn't fail a whole call.
+
+// FlexString is a string that also accepts a JSON number or boolean, kept in
+// its literal form. null decodes to "".
+type FlexString string
+
+func (s *FlexString) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		*s = ""
+	case len(b) > 0 && b[0] == '"':
+		var v string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*s = FlexString(v)
+	case len(b) > 0 && (b[0] == '{' || b[0] == '['):
+		return fmt.Errorf("dnspod: cannot decode %s into a string", b)
+	default:
+		*s = FlexString(b)
+	}
+	return nil
+}
+
+func (s FlexString) String() string { return string(s) }
+
+// FlexInt is an int that also accepts a quoted number. null and "" decode
+// to 0.
+type FlexInt int
+
+func (n *FlexInt) UnmarshalJSON(b []byte) error {
+	var s FlexString
+	if err := s.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	v := strings.TrimSpace(string(s))
+	if v == "" {
+		*n = 0
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("dnspod: cannot decode %s into an int", b)
+	}
+	*n = FlexInt(i)
+	return nil
+}
+
+func (n FlexInt) String() string { return strconv.Itoa(int(n)) }
+
+// FlexBool is a bool that also accepts "1"/"0", 1/0, "yes"/"no" and
+// "enable"/"disable". Any other value, including null, "" and objects,
+// decodes to false rather than failing the whole response.
+type FlexBool bool
+
+func (v *FlexBool) UnmarshalJSON(b []byte) error {
+	var s FlexString
+	if err := s.UnmarshalJSON(b); err != nil {
+		*v = false
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(s))) {
+	case "1", "true", "yes", "enable", "enabled":
+		*v = true
+	default:
+		*v = false
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ipdetect
+
+import (
+	"net"
+	"net/netip"
+)
+
+// AddrClass is the kind of address range an IP belongs to.
+type AddrClass string
+
+const (
+	ClassPublic AddrClass = "public"
+	// ClassPrivate is RFC 1918 space.
+	ClassPrivate AddrClass = "private"
+	// ClassCGNAT is the shared address space of carrier-grade NAT, RFC 6598.
+	ClassCGNAT AddrClass = "cgnat"
+	// ClassULA is IPv6 unique local space, RFC 4193.
+	ClassULA AddrClass = "ula"
+	// ClassDocumentation is reserved for examples, RFC 5737 and RFC 3849.
+	ClassDocumentation AddrClass = "documentation"
+	// ClassBogon is everything else that is not routable on the internet:
+	// loopback, link-local, multicast, benchmarking, reserved and unspecified
+	// addresses.
+	ClassBogon AddrClass = "bogon"
+)
+
+var classRanges = []struct {
+	prefix netip.Prefix
+	class  AddrClass
+}{
+	{netip.MustParsePrefix("10.0.0.0/8"), ClassPrivate},
+	{netip.MustParsePrefix("172.16.0.0/12"), ClassPrivate},
+	{netip.MustParsePrefix("192.168.0.0/16"), ClassPrivate},
+	{netip.MustParsePrefix("100.64.0.0/10"), ClassCGNAT},
+	{netip.MustParsePrefix("fc00::/7"), ClassULA},
+	{netip.MustParsePrefix("192.0.2.0/24"), ClassDocumentation},
+	{netip.MustParsePrefix("198.51.100.0/24"), ClassDocumentation},
+	{netip.MustParsePrefix("203.0.113.0/24"), ClassDocumentation},
+	{netip.MustParsePrefix("2001:db8::/32"), ClassDocumentation},
+	{netip.MustParsePrefix("0.0.0.0/8"), ClassBogon},
+	{netip.MustParsePrefix("127.0.0.0/8"), ClassBogon},
+	{netip.MustParsePrefix("169.254.0.0/16"), ClassBogon},
+	{netip.MustParsePrefix("192.0.0.0/24"), ClassBogon},
+	{netip.MustParsePrefix("198.18.0.0/15"), ClassBogon},
+	{netip.MustParsePrefix("224.0.0.0/4"), ClassBogon},
+	{netip.MustParsePrefix("240.0.0.0/4"), ClassBogon},
+	{netip.MustParsePrefix("::/128"), ClassBogon},
+	{netip.MustParsePrefix("::1/128"), ClassBogon},
+	{netip.MustParsePrefix("fe80::/10"), ClassBogon},
+	{netip.MustParsePrefix("ff00::/8"), ClassBogon},
+}
+
+// Classify returns the class of ip. An invalid ip is a bogon.
+func Classify(ip net.IP) AddrClass {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ClassBogon
+	}
+	return classify(addr.Unmap())
+}
+
+func classify(addr netip.Addr) AddrClass {
+	for _, r := range classRanges {
+		if r.prefix.Contains(addr) {
+			return r.class
+		}
+	}
+	return ClassPublic
+}
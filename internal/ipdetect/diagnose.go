@@ -0,0 +1,48 @@
+package ipdetect
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Result is the outcome of a single detection method; see DetectAll.
+type Result struct {
+	Method string
+	IP     net.IP
+	Source string
+	Err    error
+}
+
+// DetectAll runs every detection method on its own, without fallbacks, so
+// it shows why a method failed. The iface and wifi methods are only tried
+// when opt configures them. Results are in the order auto mode tries them.
+func DetectAll(ctx context.Context, opt Options) []Result {
+	var out []Result
+	add := func(method string, ip net.IP, src string, err error) {
+		out = append(out, Result{Method: method, IP: ip, Source: src, Err: err})
+	}
+
+	d := NewDetector(opt)
+	if ssid := strings.TrimSpace(opt.WiFiSSID); ssid != "" {
+		ip, src, err := NewDetector(Options{WiFiSSID: ssid, Logger: opt.Logger, System: opt.System}).DetectIPv4(ctx)
+		add("wifi", ip, src, err)
+	}
+	if opt.PreferredIface != "" {
+		ip, err := d.ipv4FromIface(ctx, opt.PreferredIface)
+		add("iface", ip, "iface:"+opt.PreferredIface, err)
+	}
+	ip, src, err := d.ipv4FromDefaultRoute(ctx)
+	add("route", ip, src, err)
+	ip, err = d.ipv4FromUDP(ctx)
+	add("udp", ip, "udp", err)
+	ip, ifname, err := d.ipv4FromAnyNonLoopback(ctx)
+	add("any", ip, "any:"+ifname, err)
+
+	for i := range out {
+		if out[i].Err != nil {
+			out[i].Source = ""
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package ipdetect
+
+import (
+	"net"
+	"net/netip"
+	"path"
+)
+
+// Address scopes for Filter.Scope.
+const (
+	ScopeAny     = "any"
+	ScopePublic  = "public"
+	ScopePrivate = "private"
+)
+
+// Filter restricts the interfaces and addresses every detection method may
+// return. The zero Filter accepts everything.
+type Filter struct {
+	// Allow, if not empty, lists the prefixes an address must be in.
+	Allow []netip.Prefix
+	// Deny lists prefixes that are never returned. It wins over Allow.
+	Deny []netip.Prefix
+	// ExcludeIfaces are interface name globs (path.Match syntax), e.g.
+	// "docker*" or "wg*".
+	ExcludeIfaces []string
+	// Scope is ScopeAny (or ""), ScopePublic or ScopePrivate. Public
+	// addresses are those Classify reports as ClassPublic, the same rule
+	// the updater applies before publishing. Private addresses are RFC 1918
+	// and CGNAT (100.64.0.0/10).
+	Scope string
+}
+
+// excludesIface reports whether name matches one of the exclusion globs.
+func (f Filter) excludesIface(name string) bool {
+	for _, pattern := range f.ExcludeIfaces {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether ip passes the scope, deny and allow rules.
+func (f Filter) allows(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip.To4())
+	if !ok {
+		return false
+	}
+	switch f.Scope {
+	case ScopePublic:
+		if classify(addr) != ClassPublic {
+			return false
+		}
+	case ScopePrivate:
+		if !isPrivateIPv4(addr) {
+			return false
+		}
+	}
+	for _, p := range f.Deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, p := range f.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateIPv4(addr netip.Addr) bool {
+	c := classify(addr)
+	return c == ClassPrivate || c == ClassCGNAT
+}
@@ -1,10 +1,11 @@
 package ipdetect
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"runtime"
 	"strings"
 )
 
@@ -14,10 +15,24 @@ type Options struct {
 	Method string
 	// Optional: only accept an IPv4 from the WiFi interface connected to this SSID.
 	WiFiSSID string
+	// RouteTable is the routing table the route method looks in; 0 means
+	// the main table.
+	RouteTable int
+	// RouteFwmark, if set, makes the route method follow the policy routing
+	// rules for packets with this firewall mark instead.
+	RouteFwmark uint32
+	// Filter restricts the interfaces and addresses of every method.
+	Filter Filter
+	// Logger receives debug output about failed methods. Defaults to slog.Default().
+	Logger *slog.Logger
+	// System is the host to inspect. Defaults to the machine the process
+	// runs on.
+	System System
 }
 
 type Detector struct {
 	opt Options
+	sys System
 }
 
 var (
@@ -29,19 +44,28 @@ func NewDetector(opt Options) *Detector {
 	if opt.Method == "" {
 		opt.Method = "auto"
 	}
-	return &Detector{opt: opt}
+	if opt.Logger == nil {
+		opt.Logger = slog.Default()
+	}
+	sys := opt.System
+	if sys == nil {
+		sys = hostSystem{}
+	}
+	return &Detector{opt: opt, sys: sys}
 }
 
-func (d *Detector) DetectIPv4() (net.IP, string, error) {
+// DetectIPv4 returns the address and where it was found, e.g. "udp". It
+// stops trying further methods once ctx is done and returns ctx.Err().
+func (d *Detector) DetectIPv4(ctx context.Context) (net.IP, string, error) {
 	if ssid := strings.TrimSpace(d.opt.WiFiSSID); ssid != "" {
-		ifname, actual, err := wifiIfaceForSSID(ssid)
+		ifname, actual, err := d.wifiIfaceForSSID(ctx, ssid)
 		if err != nil {
 			return nil, "", err
 		}
 		if d.opt.PreferredIface != "" && d.opt.PreferredIface != ifname {
 			return nil, "", fmt.Errorf("%w: preferred iface=%s but ssid %q is on iface=%s", ErrWiFiSSIDNotMatched, d.opt.PreferredIface, actual, ifname)
 		}
-		ip, err := ipv4FromIface(ifname)
+		ip, err := d.ipv4FromIface(ctx, ifname)
 		if err != nil {
 			return nil, "", err
 		}
@@ -50,41 +74,52 @@ func (d *Detector) DetectIPv4() (net.IP, string, error) {
 
 	// If user pins iface, use it first.
 	if d.opt.PreferredIface != "" {
-		ip, err := ipv4FromIface(d.opt.PreferredIface)
+		ip, err := d.ipv4FromIface(ctx, d.opt.PreferredIface)
 		if err == nil {
 			return ip, "iface:" + d.opt.PreferredIface, nil
 		}
-		if d.opt.Method == "iface" {
+		if d.opt.Method == "iface" || ctx.Err() != nil {
 			return nil, "", err
 		}
+		d.opt.Logger.Debug("preferred iface failed, falling back", "method", d.opt.Method, "iface", d.opt.PreferredIface, "error", err)
 	}
 
 	switch d.opt.Method {
 	case "auto":
-		// Prefer route-based on Linux; otherwise UDP fallback.
-		if runtime.GOOS == "linux" {
-			if ip, ifname, err := ipv4FromDefaultRouteLinux(); err == nil {
-				return ip, "route:" + ifname, nil
-			}
+		// Prefer route-based; the route table is only readable on Linux.
+		ip, src, err := d.ipv4FromDefaultRoute(ctx)
+		if err == nil {
+			return ip, src, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
 		}
-		if ip, err := ipv4FromUDP(); err == nil {
+		d.opt.Logger.Debug("route detection failed", "method", "route", "error", err)
+		ip, err = d.ipv4FromUDP(ctx)
+		if err == nil {
 			return ip, "udp", nil
 		}
-		if ip, ifname, err := ipv4FromAnyNonLoopback(); err == nil {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		d.opt.Logger.Debug("udp detection failed", "method", "udp", "error", err)
+		ip, ifname, err := d.ipv4FromAnyNonLoopback(ctx)
+		if err == nil {
 			return ip, "any:" + ifname, nil
 		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		d.opt.Logger.Debug("interface scan failed", "method", "any", "error", err)
 		return nil, "", errors.New("failed to detect IPv4")
 	case "route":
-		if runtime.GOOS != "linux" {
-			return nil, "", fmt.Errorf("method=route requires linux (current %s)", runtime.GOOS)
-		}
-		ip, ifname, err := ipv4FromDefaultRouteLinux()
+		ip, src, err := d.ipv4FromDefaultRoute(ctx)
 		if err != nil {
 			return nil, "", err
 		}
-		return ip, "route:" + ifname, nil
+		return ip, src, nil
 	case "udp":
-		ip, err := ipv4FromUDP()
+		ip, err := d.ipv4FromUDP(ctx)
 		if err != nil {
 			return nil, "", err
 		}
@@ -93,7 +128,7 @@ func (d *Detector) DetectIPv4() (net.IP, string, error) {
 		if d.opt.PreferredIface == "" {
 			return nil, "", errors.New("method=iface requires IP_PREFERRED_IFACE")
 		}
-		ip, err := ipv4FromIface(d.opt.PreferredIface)
+		ip, err := d.ipv4FromIface(ctx, d.opt.PreferredIface)
 		if err != nil {
 			return nil, "", err
 		}
@@ -103,29 +138,94 @@ func (d *Detector) DetectIPv4() (net.IP, string, error) {
 	}
 }
 
-func ipv4FromIface(ifname string) (net.IP, error) {
-	iface, err := net.InterfaceByName(ifname)
+// wifiIfaceForSSID finds a WiFi interface which is currently associated with the
+// given SSID. It returns the interface name and the actual SSID.
+func (d *Detector) wifiIfaceForSSID(ctx context.Context, targetSSID string) (string, string, error) {
+	targetSSID = strings.TrimSpace(targetSSID)
+	if targetSSID == "" {
+		return "", "", fmt.Errorf("%w: empty ssid", ErrWiFiSSIDUnavailable)
+	}
+	links, err := d.sys.WiFiLinks(ctx)
 	if err != nil {
-		return nil, err
+		return "", "", err
+	}
+	if len(links) == 0 {
+		return "", "", fmt.Errorf("%w: no associated wifi interface found", ErrWiFiSSIDUnavailable)
+	}
+	var found []string
+	for _, l := range links {
+		if l.SSID == targetSSID {
+			return l.Iface, l.SSID, nil
+		}
+		found = append(found, fmt.Sprintf("%s=%q", l.Iface, l.SSID))
 	}
-	addrs, err := iface.Addrs()
+	return "", "", fmt.Errorf("%w: want %q, found %s", ErrWiFiSSIDNotMatched, targetSSID, strings.Join(found, ", "))
+}
+
+func (d *Detector) ipv4FromIface(ctx context.Context, ifname string) (net.IP, error) {
+	ifaces, err := d.sys.Interfaces(ctx)
 	if err != nil {
 		return nil, err
 	}
+	var iface *Interface
+	for i := range ifaces {
+		if ifaces[i].Name == ifname {
+			iface = &ifaces[i]
+			break
+		}
+	}
+	if iface == nil {
+		return nil, fmt.Errorf("no such network interface: %s", ifname)
+	}
+	if d.opt.Filter.excludesIface(ifname) {
+		return nil, fmt.Errorf("iface %s is excluded by IP_EXCLUDE_IFACES", ifname)
+	}
+	ip, filtered := d.pickIPv4(iface.Addrs)
+	if ip != nil {
+		return ip, nil
+	}
+	if len(filtered) > 0 {
+		return nil, fmt.Errorf("no usable IPv4 found on iface %s (filtered out: %s)", ifname, strings.Join(filtered, ", "))
+	}
+	return nil, fmt.Errorf("no usable IPv4 found on iface %s", ifname)
+}
+
+// pickIPv4 returns the first usable address that passes the filter, and
+// the usable addresses the filter rejected.
+func (d *Detector) pickIPv4(addrs []net.Addr) (net.IP, []string) {
+	var filtered []string
 	for _, a := range addrs {
 		ip := addrToIPv4(a)
-		if ip == nil {
+		if !isUsableIPv4(ip) {
 			continue
 		}
-		if isUsableIPv4(ip) {
-			return ip, nil
+		if !d.opt.Filter.allows(ip) {
+			filtered = append(filtered, ip.String())
+			continue
 		}
+		return ip, nil
 	}
-	return nil, fmt.Errorf("no usable IPv4 found on iface %s", ifname)
+	return nil, filtered
 }
 
-func ipv4FromAnyNonLoopback() (net.IP, string, error) {
-	ifaces, err := net.Interfaces()
+// ifaceOf returns the name of the interface that has ip.
+func (d *Detector) ifaceOf(ctx context.Context, ip net.IP) (string, error) {
+	ifaces, err := d.sys.Interfaces(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		for _, a := range iface.Addrs {
+			if addrToIPv4(a).Equal(ip) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has %s", ip)
+}
+
+func (d *Detector) ipv4FromAnyNonLoopback(ctx context.Context) (net.IP, string, error) {
+	ifaces, err := d.sys.Interfaces(ctx)
 	if err != nil {
 		return nil, "", err
 	}
@@ -136,18 +236,11 @@ func ipv4FromAnyNonLoopback() (net.IP, string, error) {
 		if iface.Flags&net.FlagLoopback != 0 {
 			continue
 		}
-		addrs, err := iface.Addrs()
-		if err != nil {
+		if d.opt.Filter.excludesIface(iface.Name) {
 			continue
 		}
-		for _, a := range addrs {
-			ip := addrToIPv4(a)
-			if ip == nil {
-				continue
-			}
-			if isUsableIPv4(ip) {
-				return ip, iface.Name, nil
-			}
+		if ip, _ := d.pickIPv4(iface.Addrs); ip != nil {
+			return ip, iface.Name, nil
 		}
 	}
 	return nil, "", errors.New("no usable IPv4 found")
@@ -0,0 +1,733 @@
+package ipdetect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSystem is a System with a fixed network configuration.
+type fakeSystem struct {
+	routes    []Route
+	routesErr error
+	rules     []Rule
+	ifaces    []Interface
+	// udpSource is the local address of dialed UDP connections; nil makes
+	// Dial fail.
+	udpSource net.IP
+	// hang makes DialContext and WiFiLinks block until ctx is done.
+	hang    bool
+	wifi    []WiFiLink
+	wifiErr error
+}
+
+func (s *fakeSystem) Routes(ctx context.Context) ([]Route, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.routes, s.routesErr
+}
+
+func (s *fakeSystem) Rules(ctx context.Context) ([]Rule, error) { return s.rules, ctx.Err() }
+
+func (s *fakeSystem) Interfaces(ctx context.Context) ([]Interface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.ifaces, nil
+}
+
+func (s *fakeSystem) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if s.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.udpSource == nil {
+		return nil, errors.New("network is unreachable")
+	}
+	return fakeConn{local: &net.UDPAddr{IP: s.udpSource, Port: 40000}}, nil
+}
+
+func (s *fakeSystem) WiFiLinks(ctx context.Context) ([]WiFiLink, error) {
+	if s.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.wifi, s.wifiErr
+}
+
+type fakeConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c fakeConn) LocalAddr() net.Addr           { return c.local }
+func (c fakeConn) SetDeadline(t time.Time) error { return nil }
+func (c fakeConn) Close() error                  { return nil }
+
+func iface(name string, flags net.Flags, cidrs ...string) Interface {
+	i := Interface{Name: name, Flags: flags}
+	for _, c := range cidrs {
+		ip, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		n.IP = ip
+		i.Addrs = append(i.Addrs, n)
+	}
+	return i
+}
+
+func defaultRoute(ifname string, metric int) Route {
+	return Route{
+		Iface:       ifname,
+		Destination: net.IPv4zero.To4(),
+		Mask:        net.CIDRMask(0, 32),
+		Gateway:     net.IPv4(192, 168, 1, 1).To4(),
+		Flags:       RTFUp | RTFGateway,
+		Metric:      metric,
+		Table:       TableMain,
+	}
+}
+
+const up = net.FlagUp | net.FlagBroadcast
+
+// lan is a host with eth0 on the default route, a WiFi interface and a
+// Docker bridge.
+func lan() *fakeSystem {
+	return &fakeSystem{
+		routes: []Route{
+			{Iface: "docker0", Destination: net.IPv4(172, 17, 0, 0).To4(), Mask: net.CIDRMask(16, 32), Flags: RTFUp, Table: TableMain},
+			defaultRoute("eth0", 100),
+		},
+		ifaces: []Interface{
+			iface("lo", net.FlagUp|net.FlagLoopback, "127.0.0.1/8"),
+			iface("docker0", up, "172.17.0.1/16"),
+			iface("eth0", up, "fe80::1/64", "169.254.10.1/16", "192.168.1.10/24"),
+			iface("wlan0", up, "10.0.0.5/24"),
+		},
+		udpSource: net.IPv4(192, 168, 1, 10),
+		wifi:      []WiFiLink{{Iface: "wlan0", SSID: "home"}},
+	}
+}
+
+func TestDetectIPv4(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     Options
+		sys     func(s *fakeSystem)
+		wantIP  string
+		wantSrc string
+		wantErr string
+	}{
+		{name: "auto uses the default route", wantIP: "192.168.1.10", wantSrc: "route:eth0 via 192.168.1.1"},
+		{
+			name:    "auto falls back to udp without a default route",
+			sys:     func(s *fakeSystem) { s.routes = s.routes[:1]; s.udpSource = net.IPv4(10, 0, 0, 5) },
+			wantIP:  "10.0.0.5",
+			wantSrc: "udp",
+		},
+		{
+			name:    "auto falls back to udp when the route table is unreadable",
+			sys:     func(s *fakeSystem) { s.routesErr = errors.New("permission denied") },
+			wantIP:  "192.168.1.10",
+			wantSrc: "udp",
+		},
+		{
+			name:    "auto falls back to udp when the route iface has no address",
+			sys:     func(s *fakeSystem) { s.ifaces[2] = iface("eth0", up, "169.254.10.1/16") },
+			wantIP:  "192.168.1.10",
+			wantSrc: "udp",
+		},
+		{
+			name:    "auto scans interfaces last",
+			sys:     func(s *fakeSystem) { s.routes = nil; s.udpSource = nil },
+			wantIP:  "172.17.0.1",
+			wantSrc: "any:docker0",
+		},
+		{
+			name:    "auto skips down interfaces",
+			sys:     func(s *fakeSystem) { s.routes = nil; s.udpSource = nil; s.ifaces[1].Flags = 0 },
+			wantIP:  "192.168.1.10",
+			wantSrc: "any:eth0",
+		},
+		{
+			name:    "auto fails without any address",
+			sys:     func(s *fakeSystem) { s.routes = nil; s.udpSource = nil; s.ifaces = s.ifaces[:1] },
+			wantErr: "failed to detect IPv4",
+		},
+		{
+			name:    "preferred iface comes first",
+			opt:     Options{PreferredIface: "wlan0"},
+			wantIP:  "10.0.0.5",
+			wantSrc: "iface:wlan0",
+		},
+		{
+			name:    "missing preferred iface falls back",
+			opt:     Options{PreferredIface: "eth1"},
+			wantIP:  "192.168.1.10",
+			wantSrc: "route:eth0 via 192.168.1.1",
+		},
+		{
+			name:    "route",
+			opt:     Options{Method: "route"},
+			wantIP:  "192.168.1.10",
+			wantSrc: "route:eth0 via 192.168.1.1",
+		},
+		{
+			name:    "route does not fall back",
+			opt:     Options{Method: "route"},
+			sys:     func(s *fakeSystem) { s.routes = s.routes[:1] },
+			wantErr: "default route not found",
+		},
+		{
+			name:    "route ignores routes that are down",
+			opt:     Options{Method: "route"},
+			sys:     func(s *fakeSystem) { s.routes[1].Flags = RTFGateway },
+			wantErr: "default route not found",
+		},
+		{
+			name:    "udp",
+			opt:     Options{Method: "udp"},
+			wantIP:  "192.168.1.10",
+			wantSrc: "udp",
+		},
+		{
+			name:    "udp rejects a loopback source",
+			opt:     Options{Method: "udp"},
+			sys:     func(s *fakeSystem) { s.udpSource = net.IPv4(127, 0, 0, 1) },
+			wantErr: "udp source-ip detection failed",
+		},
+		{
+			name:    "iface",
+			opt:     Options{Method: "iface", PreferredIface: "eth0"},
+			wantIP:  "192.168.1.10",
+			wantSrc: "iface:eth0",
+		},
+		{
+			name:    "iface does not fall back",
+			opt:     Options{Method: "iface", PreferredIface: "eth1"},
+			wantErr: "no such network interface: eth1",
+		},
+		{
+			name:    "iface requires an interface",
+			opt:     Options{Method: "iface"},
+			wantErr: "requires IP_PREFERRED_IFACE",
+		},
+		{
+			name:    "unknown method",
+			opt:     Options{Method: "dns"},
+			wantErr: "unknown IP_DETECT_METHOD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := lan()
+			if tt.sys != nil {
+				tt.sys(sys)
+			}
+			opt := tt.opt
+			opt.System = sys
+			opt.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			ip, src, err := NewDetector(opt).DetectIPv4(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DetectIPv4() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DetectIPv4() error = %v", err)
+			}
+			if ip.String() != tt.wantIP || src != tt.wantSrc {
+				t.Errorf("DetectIPv4() = %s, %q; want %s, %q", ip, src, tt.wantIP, tt.wantSrc)
+			}
+		})
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	vpn := func(table int) Route {
+		r := defaultRoute("wg0", 0)
+		r.Gateway, r.Flags, r.Table = nil, RTFUp, table
+		return r
+	}
+	backup := defaultRoute("eth1", 600)
+	backup.Gateway = net.IPv4(10, 1, 0, 1).To4()
+	// The rules wg-quick installs for a full tunnel on table 51820.
+	wgRules := []Rule{
+		{Priority: 0, Table: 255, ToTable: true, SuppressPrefixlen: -1},
+		{Priority: 32764, Table: TableMain, ToTable: true, SuppressPrefixlen: 0},
+		{Priority: 32765, Table: 51820, ToTable: true, Fwmark: 0xca6c, FwMask: 0xffffffff, Invert: true, SuppressPrefixlen: -1},
+		{Priority: 32766, Table: TableMain, ToTable: true, SuppressPrefixlen: -1},
+	}
+
+	tests := []struct {
+		name      string
+		opt       Options
+		routes    []Route
+		rules     []Rule
+		wantIface string
+		wantGW    string
+		wantErr   string
+	}{
+		{
+			name:      "lowest metric wins",
+			routes:    []Route{backup, defaultRoute("eth0", 100)},
+			wantIface: "eth0",
+			wantGW:    "192.168.1.1",
+		},
+		{
+			name:      "order does not matter",
+			routes:    []Route{defaultRoute("eth0", 100), backup},
+			wantIface: "eth0",
+			wantGW:    "192.168.1.1",
+		},
+		{
+			name:      "other tables are ignored",
+			routes:    []Route{vpn(51820), backup},
+			wantIface: "eth1",
+			wantGW:    "10.1.0.1",
+		},
+		{
+			name:      "configured table",
+			opt:       Options{RouteTable: 51820},
+			routes:    []Route{vpn(51820), defaultRoute("eth0", 100)},
+			wantIface: "wg0",
+		},
+		{
+			name:    "configured table without a default route",
+			opt:     Options{RouteTable: 100},
+			routes:  []Route{vpn(51820), defaultRoute("eth0", 100)},
+			wantErr: "default route not found in table 100",
+		},
+		{
+			name:      "unmarked traffic goes through the tunnel",
+			opt:       Options{RouteFwmark: 0x1},
+			routes:    []Route{vpn(51820), defaultRoute("eth0", 100)},
+			rules:     wgRules,
+			wantIface: "wg0",
+		},
+		{
+			name:      "marked traffic bypasses the tunnel",
+			opt:       Options{RouteFwmark: 0xca6c},
+			routes:    []Route{vpn(51820), defaultRoute("eth0", 100)},
+			rules:     wgRules,
+			wantIface: "eth0",
+			wantGW:    "192.168.1.1",
+		},
+		{
+			name:   "mask",
+			opt:    Options{RouteFwmark: 0x1ff},
+			routes: []Route{vpn(100), defaultRoute("eth0", 100)},
+			rules: []Rule{
+				{Priority: 100, Table: 100, ToTable: true, Fwmark: 0x100, FwMask: 0xf00, SuppressPrefixlen: -1},
+				{Priority: 32766, Table: TableMain, ToTable: true, SuppressPrefixlen: -1},
+			},
+			wantIface: "wg0",
+		},
+		{
+			name:   "selective and non-table rules are skipped",
+			opt:    Options{RouteFwmark: 0x1},
+			routes: []Route{vpn(100), defaultRoute("eth0", 100)},
+			rules: []Rule{
+				{Priority: 10, Table: 100, ToTable: true, Selective: true, SuppressPrefixlen: -1},
+				{Priority: 20, ToTable: false, SuppressPrefixlen: -1},
+				{Priority: 32766, Table: TableMain, ToTable: true, SuppressPrefixlen: -1},
+			},
+			wantIface: "eth0",
+			wantGW:    "192.168.1.1",
+		},
+		{
+			name:    "no matching rule",
+			opt:     Options{RouteFwmark: 0x1},
+			routes:  []Route{defaultRoute("eth0", 100)},
+			rules:   []Rule{{Priority: 0, Table: 255, ToTable: true, SuppressPrefixlen: -1}},
+			wantErr: "default route not found for fwmark 0x1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := tt.opt
+			opt.System = &fakeSystem{routes: tt.routes, rules: tt.rules}
+			r, err := NewDetector(opt).DefaultRoute(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("DefaultRoute() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DefaultRoute() error = %v", err)
+			}
+			gw := ""
+			if r.Gateway != nil {
+				gw = r.Gateway.String()
+			}
+			if r.Iface != tt.wantIface || gw != tt.wantGW {
+				t.Errorf("DefaultRoute() = %s via %q, want %s via %q", r.Iface, gw, tt.wantIface, tt.wantGW)
+			}
+		})
+	}
+}
+
+func TestDetectIPv4WiFi(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     Options
+		wifi    []WiFiLink
+		wifiErr error
+		wantIP  string
+		wantSrc string
+		wantErr error
+	}{
+		{
+			name:    "matching ssid",
+			opt:     Options{WiFiSSID: "home"},
+			wantIP:  "10.0.0.5",
+			wantSrc: "wifi:wlan0 ssid:home",
+		},
+		{
+			name:    "ssid is trimmed",
+			opt:     Options{WiFiSSID: " home "},
+			wantIP:  "10.0.0.5",
+			wantSrc: "wifi:wlan0 ssid:home",
+		},
+		{
+			name:    "second interface matches",
+			opt:     Options{WiFiSSID: "office"},
+			wifi:    []WiFiLink{{Iface: "wlan1", SSID: "cafe"}, {Iface: "wlan0", SSID: "office"}},
+			wantIP:  "10.0.0.5",
+			wantSrc: "wifi:wlan0 ssid:office",
+		},
+		{
+			name:    "other ssid",
+			opt:     Options{WiFiSSID: "office"},
+			wantErr: ErrWiFiSSIDNotMatched,
+		},
+		{
+			name:    "ssid is case sensitive",
+			opt:     Options{WiFiSSID: "Home"},
+			wantErr: ErrWiFiSSIDNotMatched,
+		},
+		{
+			name:    "preferred iface differs",
+			opt:     Options{WiFiSSID: "home", PreferredIface: "wlan1"},
+			wantErr: ErrWiFiSSIDNotMatched,
+		},
+		{
+			name:    "not associated",
+			opt:     Options{WiFiSSID: "home"},
+			wifi:    []WiFiLink{},
+			wantErr: ErrWiFiSSIDUnavailable,
+		},
+		{
+			name:    "nl80211 unavailable",
+			opt:     Options{WiFiSSID: "home"},
+			wifiErr: ErrWiFiSSIDUnavailable,
+			wantErr: ErrWiFiSSIDUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := lan()
+			if tt.wifi != nil {
+				sys.wifi = tt.wifi
+			}
+			sys.wifiErr = tt.wifiErr
+			opt := tt.opt
+			opt.System = sys
+
+			ip, src, err := NewDetector(opt).DetectIPv4(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DetectIPv4() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DetectIPv4() error = %v", err)
+			}
+			if ip.String() != tt.wantIP || src != tt.wantSrc {
+				t.Errorf("DetectIPv4() = %s, %q; want %s, %q", ip, src, tt.wantIP, tt.wantSrc)
+			}
+		})
+	}
+}
+
+func TestDetectIPv4Context(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		opt     Options
+		ctx     context.Context
+		hang    bool
+		wantErr error
+	}{
+		{name: "canceled before the route lookup", ctx: canceled, wantErr: context.Canceled},
+		{name: "canceled iface", opt: Options{Method: "iface", PreferredIface: "eth0"}, ctx: canceled, wantErr: context.Canceled},
+		{name: "canceled preferred iface does not fall back", opt: Options{PreferredIface: "eth0"}, ctx: canceled, wantErr: context.Canceled},
+		{name: "udp dial times out", opt: Options{Method: "udp"}, hang: true, wantErr: context.DeadlineExceeded},
+		{name: "auto stops at the deadline instead of scanning", hang: true, wantErr: context.DeadlineExceeded},
+		{name: "wifi times out", opt: Options{WiFiSSID: "home"}, hang: true, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := lan()
+			sys.hang = tt.hang
+			if tt.hang {
+				// Without a default route, auto would reach the hanging dial.
+				sys.routes = nil
+			}
+			opt := tt.opt
+			opt.System = sys
+			opt.Logger = logger
+			ctx := tt.ctx
+			if ctx == nil {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+			}
+
+			start := time.Now()
+			_, _, err := NewDetector(opt).DetectIPv4(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DetectIPv4() error = %v, want %v", err, tt.wantErr)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("DetectIPv4() took %s", d)
+			}
+		})
+	}
+}
+
+func TestDetectIPv4Filter(t *testing.T) {
+	// lan plus a Tailscale interface and a second, public address on eth0.
+	sys := func() *fakeSystem {
+		s := lan()
+		s.ifaces = append(s.ifaces, iface("tailscale0", up, "100.101.102.103/32"))
+		s.ifaces[2].Addrs = append(s.ifaces[2].Addrs, iface("", 0, "9.9.9.7/24").Addrs...)
+		return s
+	}
+	prefixes := func(list ...string) []netip.Prefix {
+		var out []netip.Prefix
+		for _, s := range list {
+			out = append(out, netip.MustParsePrefix(s))
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		opt     Options
+		sys     func(s *fakeSystem)
+		wantIP  string
+		wantSrc string
+		wantErr string
+	}{
+		{
+			name:    "zero filter",
+			opt:     Options{Method: "iface", PreferredIface: "eth0"},
+			wantIP:  "192.168.1.10",
+			wantSrc: "iface:eth0",
+		},
+		{
+			name:    "public scope skips private addresses",
+			opt:     Options{Method: "iface", PreferredIface: "eth0", Filter: Filter{Scope: ScopePublic}},
+			wantIP:  "9.9.9.7",
+			wantSrc: "iface:eth0",
+		},
+		{
+			name:    "public scope rejects cgnat",
+			opt:     Options{Method: "iface", PreferredIface: "tailscale0", Filter: Filter{Scope: ScopePublic}},
+			wantErr: "no usable IPv4 found on iface tailscale0 (filtered out: 100.101.102.103)",
+		},
+		{
+			name: "public scope rejects documentation addresses",
+			opt:  Options{Method: "iface", PreferredIface: "eth0", Filter: Filter{Scope: ScopePublic}},
+			sys: func(s *fakeSystem) {
+				s.ifaces[2].Addrs = append(s.ifaces[2].Addrs[:3], iface("", 0, "198.51.100.7/24").Addrs...)
+			},
+			wantErr: "no usable IPv4 found on iface eth0 (filtered out: 192.168.1.10, 198.51.100.7)",
+		},
+		{
+			name:    "private scope",
+			opt:     Options{Method: "iface", PreferredIface: "tailscale0", Filter: Filter{Scope: ScopePrivate}},
+			wantIP:  "100.101.102.103",
+			wantSrc: "iface:tailscale0",
+		},
+		{
+			name:    "deny",
+			opt:     Options{Method: "iface", PreferredIface: "eth0", Filter: Filter{Deny: prefixes("192.168.0.0/16")}},
+			wantIP:  "9.9.9.7",
+			wantSrc: "iface:eth0",
+		},
+		{
+			name:    "allow",
+			opt:     Options{Method: "route", Filter: Filter{Allow: prefixes("9.9.9.0/24")}},
+			wantIP:  "9.9.9.7",
+			wantSrc: "route:eth0 via 192.168.1.1",
+		},
+		{
+			name:    "deny wins over allow",
+			opt:     Options{Method: "iface", PreferredIface: "eth0", Filter: Filter{Allow: prefixes("0.0.0.0/0"), Deny: prefixes("192.168.1.10/32", "9.9.9.7/32")}},
+			wantErr: "filtered out: 192.168.1.10, 9.9.9.7",
+		},
+		{
+			name:    "excluded preferred iface",
+			opt:     Options{Method: "iface", PreferredIface: "tailscale0", Filter: Filter{ExcludeIfaces: []string{"tailscale*"}}},
+			wantErr: "iface tailscale0 is excluded",
+		},
+		{
+			name:    "excluded route iface falls back",
+			opt:     Options{Filter: Filter{ExcludeIfaces: []string{"eth*"}}},
+			sys:     func(s *fakeSystem) { s.udpSource = net.IPv4(10, 0, 0, 5) },
+			wantIP:  "10.0.0.5",
+			wantSrc: "udp",
+		},
+		{
+			name:    "udp source on an excluded iface",
+			opt:     Options{Method: "udp", Filter: Filter{ExcludeIfaces: []string{"docker*", "eth0"}}},
+			wantErr: "udp source 192.168.1.10 is on iface eth0, which is excluded",
+		},
+		{
+			name:    "udp source filtered out",
+			opt:     Options{Method: "udp", Filter: Filter{Scope: ScopePublic}},
+			wantErr: "udp source 192.168.1.10 is filtered out",
+		},
+		{
+			name:    "scan skips excluded interfaces",
+			opt:     Options{Filter: Filter{ExcludeIfaces: []string{"docker*", "veth*"}}},
+			sys:     func(s *fakeSystem) { s.routes = nil; s.udpSource = nil },
+			wantIP:  "192.168.1.10",
+			wantSrc: "any:eth0",
+		},
+		{
+			name:    "scan applies the scope",
+			opt:     Options{Filter: Filter{Scope: ScopePublic}},
+			sys:     func(s *fakeSystem) { s.routes = nil; s.udpSource = nil },
+			wantIP:  "9.9.9.7",
+			wantSrc: "any:eth0",
+		},
+		{
+			name:    "wifi",
+			opt:     Options{WiFiSSID: "home", Filter: Filter{ExcludeIfaces: []string{"wlan*"}}},
+			wantErr: "iface wlan0 is excluded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sys()
+			if tt.sys != nil {
+				tt.sys(s)
+			}
+			opt := tt.opt
+			opt.System = s
+			opt.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			ip, src, err := NewDetector(opt).DetectIPv4(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DetectIPv4() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DetectIPv4() error = %v", err)
+			}
+			if ip.String() != tt.wantIP || src != tt.wantSrc {
+				t.Errorf("DetectIPv4() = %s, %q; want %s, %q", ip, src, tt.wantIP, tt.wantSrc)
+			}
+		})
+	}
+}
+
+func TestIsUsableIPv4(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"10.0.0.1", true},
+		{"100.64.0.1", true},
+		{"203.0.113.7", true},
+		{"127.0.0.1", false},
+		{"169.254.1.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isUsableIPv4(net.ParseIP(tt.ip).To4()); got != tt.want {
+			t.Errorf("isUsableIPv4(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if isUsableIPv4(nil) {
+		t.Error("isUsableIPv4(nil) = true")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want AddrClass
+	}{
+		{"1.1.1.1", ClassPublic},
+		{"172.32.0.1", ClassPublic},
+		{"2400:3200::1", ClassPublic},
+		{"172.31.255.254", ClassPrivate},
+		{"100.64.0.1", ClassCGNAT},
+		{"100.128.0.1", ClassPublic},
+		{"fd12:3456::1", ClassULA},
+		{"203.0.113.7", ClassDocumentation},
+		{"2001:db8::1", ClassDocumentation},
+		{"127.0.0.1", ClassBogon},
+		{"169.254.1.1", ClassBogon},
+		{"0.0.0.0", ClassBogon},
+		{"224.0.0.1", ClassBogon},
+		{"::ffff:192.168.1.1", ClassPrivate},
+	}
+	for _, tt := range tests {
+		if got := Classify(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Classify(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+	if got := Classify(nil); got != ClassBogon {
+		t.Errorf("Classify(nil) = %s, want %s", got, ClassBogon)
+	}
+}
+
+func TestParseRouteTable(t *testing.T) {
+	const table = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`
+	routes, err := parseRouteTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+	r := routes[0]
+	if !r.IsDefault() || r.Iface != "eth0" || r.Gateway.String() != "192.168.1.1" || r.Metric != 100 {
+		t.Errorf("route 0 = %+v", r)
+	}
+	r = routes[1]
+	if r.IsDefault() || r.Destination.String() != "192.168.1.0" || r.Mask.String() != "ffffff00" {
+		t.Errorf("route 1 = %+v", r)
+	}
+
+	if _, err := parseRouteTable(strings.NewReader("")); err == nil {
+		t.Error("empty table parsed")
+	}
+}
@@ -2,63 +2,164 @@ package ipdetect
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-// Parse /proc/net/route and locate the interface for the default route.
-// This is reliable inside a host-networked container on Linux.
-func ipv4FromDefaultRouteLinux() (net.IP, string, error) {
-	f, err := os.Open("/proc/net/route")
-	if err != nil {
-		return nil, "", err
+// Routes asks rtnetlink for the routes of all tables. If that fails, e.g.
+// under a restrictive seccomp profile, it falls back to /proc/net/route,
+// which only lists the main table.
+func (hostSystem) Routes(ctx context.Context) ([]Route, error) {
+	routes, err := netlinkRoutes(ctx)
+	if err == nil {
+		return routes, nil
+	}
+	if runtime.GOOS != "linux" || ctx.Err() != nil {
+		return nil, err
+	}
+	f, ferr := os.Open("/proc/net/route")
+	if ferr != nil {
+		return nil, errors.Join(err, ferr)
 	}
 	defer f.Close()
+	return parseRouteTable(f)
+}
 
-	scanner := bufio.NewScanner(f)
+func (hostSystem) Rules(ctx context.Context) ([]Rule, error) {
+	return netlinkRules(ctx)
+}
+
+// parseRouteTable parses the format of /proc/net/route.
+func parseRouteTable(r io.Reader) ([]Route, error) {
+	scanner := bufio.NewScanner(r)
 	// Skip header
 	if !scanner.Scan() {
-		return nil, "", errors.New("/proc/net/route is empty")
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("/proc/net/route is empty")
 	}
 
-	var ifname string
+	var routes []Route
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		fields := strings.Fields(line)
+		fields := strings.Fields(scanner.Text())
 		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
 		if len(fields) < 11 {
 			continue
 		}
-		iface := fields[0]
-		destination := fields[1]
-		flags := fields[3]
+		dst, err1 := hexIPv4(fields[1])
+		gw, err2 := hexIPv4(fields[2])
+		flags, err3 := strconv.ParseUint(fields[3], 16, 32)
+		metric, err4 := strconv.Atoi(fields[6])
+		mask, err5 := hexIPv4(fields[7])
+		if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+			return nil, fmt.Errorf("parse route %q: %w", scanner.Text(), err)
+		}
+		routes = append(routes, Route{
+			Iface:       fields[0],
+			Destination: dst,
+			Mask:        net.IPMask(mask),
+			Gateway:     gw,
+			Flags:       uint32(flags),
+			Metric:      metric,
+			Table:       TableMain,
+		})
+	}
+	return routes, scanner.Err()
+}
 
-		// Default route has Destination=00000000 and Flags has RTF_UP (0x1) + RTF_GATEWAY (0x2) usually.
-		if destination != "00000000" {
+// hexIPv4 decodes an address of /proc/net/route: 8 hex digits in host
+// (little-endian) byte order.
+func hexIPv4(s string) (net.IP, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 4 {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+	return ip, nil
+}
+
+// DefaultRoute returns the default route traffic leaves through: the one
+// with the lowest metric in the main table, in Options.RouteTable, or in the
+// table policy routing selects for Options.RouteFwmark.
+func (d *Detector) DefaultRoute(ctx context.Context) (Route, error) {
+	routes, err := d.sys.Routes(ctx)
+	if err != nil {
+		return Route{}, err
+	}
+	if d.opt.RouteFwmark == 0 {
+		table := d.opt.RouteTable
+		if table == 0 {
+			table = TableMain
+		}
+		if r, ok := bestDefaultRoute(routes, table, -1); ok {
+			return r, nil
+		}
+		return Route{}, fmt.Errorf("default route not found in table %d", table)
+	}
+
+	// Evaluate the rules like the kernel does for a packet with the mark:
+	// in priority order, until a table yields a route.
+	rules, err := d.sys.Rules(ctx)
+	if err != nil {
+		return Route{}, err
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
+	for _, rule := range rules {
+		if !rule.ToTable || !rule.matches(d.opt.RouteFwmark) {
+			continue
+		}
+		if r, ok := bestDefaultRoute(routes, rule.Table, rule.SuppressPrefixlen); ok {
+			return r, nil
+		}
+	}
+	return Route{}, fmt.Errorf("default route not found for fwmark %#x", d.opt.RouteFwmark)
+}
+
+// bestDefaultRoute returns the default route with the lowest metric in
+// table. Routes with a prefix length up to suppress are ignored.
+func bestDefaultRoute(routes []Route, table, suppress int) (Route, bool) {
+	var best Route
+	found := false
+	for _, r := range routes {
+		if r.Table != table || !r.IsDefault() || r.Iface == "" {
 			continue
 		}
-		if flags == "00000000" {
+		if ones, _ := r.Mask.Size(); ones <= suppress {
 			continue
 		}
-		ifname = iface
-		break
+		if !found || r.Metric < best.Metric {
+			best, found = r, true
+		}
 	}
-	if err := scanner.Err(); err != nil {
+	return best, found
+}
+
+// ipv4FromDefaultRoute returns the address of the default route interface
+// and the route source, e.g. "route:eth0 via 192.168.1.1".
+func (d *Detector) ipv4FromDefaultRoute(ctx context.Context) (net.IP, string, error) {
+	r, err := d.DefaultRoute(ctx)
+	if err != nil {
 		return nil, "", err
 	}
-	if ifname == "" {
-		return nil, "", errors.New("default route not found in /proc/net/route")
-	}
-
-	ip, err := ipv4FromIface(ifname)
+	ip, err := d.ipv4FromIface(ctx, r.Iface)
 	if err != nil {
-		return nil, "", fmt.Errorf("default route iface %s has no usable IPv4: %w", ifname, err)
+		return nil, "", fmt.Errorf("default route iface %s has no usable IPv4: %w", r.Iface, err)
+	}
+	src := "route:" + r.Iface
+	if r.Gateway != nil {
+		src += " via " + r.Gateway.String()
 	}
-	return ip, ifname, nil
+	return ip, src, nil
 }
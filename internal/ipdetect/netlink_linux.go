@@ -0,0 +1,194 @@
+//go:build linux
+
+package ipdetect
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// rtnetlink constants from linux/rtnetlink.h and linux/fib_rules.h.
+const (
+	rtaDst       = 1
+	rtaOIF       = 4
+	rtaGateway   = 5
+	rtaPriority  = 6
+	rtaMultipath = 9
+	rtaTable     = 15
+
+	rtnUnicast = 1
+
+	rtnhFDead     = 0x1
+	rtnhFLinkdown = 0x10
+
+	fraIifname           = 3
+	fraPriority          = 6
+	fraFwmark            = 10
+	fraSuppressPrefixlen = 14
+	fraTable             = 15
+	fraFwmask            = 16
+	fraOifname           = 17
+	fraUIDRange          = 20
+	fraIPProto           = 22
+	fraSportRange        = 23
+	fraDportRange        = 24
+
+	frActToTbl    = 1
+	fibRuleInvert = 0x2
+
+	// sizeofRtMsg is also the size of struct fib_rule_hdr.
+	sizeofRtMsg     = 12
+	sizeofRtNexthop = 8
+)
+
+// netlinkRoutes dumps the IPv4 unicast routes of all tables.
+func netlinkRoutes(ctx context.Context) ([]Route, error) {
+	msgs, err := netlinkDump(ctx, syscall.RTM_GETROUTE)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < sizeofRtMsg {
+			continue
+		}
+		hdr := m.Data[:sizeofRtMsg]
+		if hdr[0] != syscall.AF_INET || hdr[7] != rtnUnicast {
+			continue
+		}
+		attrs := parseAttrs(m.Data[sizeofRtMsg:])
+		r := Route{
+			Destination: net.IPv4zero.To4(),
+			Mask:        net.CIDRMask(int(hdr[1]), 32),
+			Table:       int(hdr[4]),
+			Flags:       RTFUp,
+		}
+		if b := attrs[rtaTable]; len(b) == 4 {
+			r.Table = int(binary.NativeEndian.Uint32(b))
+		}
+		if b := attrs[rtaDst]; len(b) == 4 {
+			r.Destination = net.IP(b)
+		}
+		if b := attrs[rtaPriority]; len(b) == 4 {
+			r.Metric = int(binary.NativeEndian.Uint32(b))
+		}
+		oif, gw := attrs[rtaOIF], attrs[rtaGateway]
+		flags := binary.NativeEndian.Uint32(hdr[8:12])
+		if mp := attrs[rtaMultipath]; len(mp) >= sizeofRtNexthop {
+			// Use the first next hop of a multipath route.
+			n := int(binary.NativeEndian.Uint16(mp[0:2]))
+			flags |= uint32(mp[2])
+			oif = mp[4:8]
+			if n > sizeofRtNexthop && n <= len(mp) {
+				gw = parseAttrs(mp[sizeofRtNexthop:n])[rtaGateway]
+			}
+		}
+		if flags&(rtnhFDead|rtnhFLinkdown) != 0 {
+			r.Flags = 0
+		}
+		if len(gw) == 4 {
+			r.Gateway = net.IP(gw)
+			r.Flags |= RTFGateway
+		}
+		if len(oif) == 4 {
+			iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(oif)))
+			if err != nil {
+				continue
+			}
+			r.Iface = iface.Name
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// netlinkRules dumps the IPv4 policy routing rules.
+func netlinkRules(ctx context.Context) ([]Rule, error) {
+	msgs, err := netlinkDump(ctx, syscall.RTM_GETRULE)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWRULE || len(m.Data) < sizeofRtMsg {
+			continue
+		}
+		hdr := m.Data[:sizeofRtMsg]
+		if hdr[0] != syscall.AF_INET {
+			continue
+		}
+		attrs := parseAttrs(m.Data[sizeofRtMsg:])
+		flags := binary.NativeEndian.Uint32(hdr[8:12])
+		r := Rule{
+			Table:             int(hdr[4]),
+			ToTable:           hdr[7] == frActToTbl,
+			Invert:            flags&fibRuleInvert != 0,
+			Selective:         hdr[1] != 0 || hdr[2] != 0,
+			SuppressPrefixlen: -1,
+		}
+		for _, a := range []uint16{fraIifname, fraOifname, fraUIDRange, fraIPProto, fraSportRange, fraDportRange} {
+			if _, ok := attrs[a]; ok {
+				r.Selective = true
+			}
+		}
+		if b := attrs[fraTable]; len(b) == 4 {
+			r.Table = int(binary.NativeEndian.Uint32(b))
+		}
+		if b := attrs[fraPriority]; len(b) == 4 {
+			r.Priority = int(binary.NativeEndian.Uint32(b))
+		}
+		if b := attrs[fraFwmark]; len(b) == 4 {
+			r.Fwmark = binary.NativeEndian.Uint32(b)
+			r.FwMask = 0xffffffff
+		}
+		if b := attrs[fraFwmask]; len(b) == 4 {
+			r.FwMask = binary.NativeEndian.Uint32(b)
+		}
+		if b := attrs[fraSuppressPrefixlen]; len(b) == 4 {
+			r.SuppressPrefixlen = int(int32(binary.NativeEndian.Uint32(b)))
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// netlinkDump dumps a routing table. The kernel answers a dump from memory
+// without waiting on the network, so ctx is only checked before it starts.
+func netlinkDump(ctx context.Context, typ int) ([]syscall.NetlinkMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b, err := syscall.NetlinkRIB(typ, syscall.AF_INET)
+	if err != nil {
+		return nil, fmt.Errorf("rtnetlink: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, fmt.Errorf("rtnetlink: %w", err)
+	}
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_ERROR {
+			return nil, errors.New("rtnetlink: dump failed")
+		}
+	}
+	return msgs, nil
+}
+
+// parseAttrs splits a sequence of struct rtattr by type.
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= 4 {
+		n := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4])
+		if n < 4 || n > len(b) {
+			break
+		}
+		attrs[typ&0x3fff] = b[4:n]
+		b = b[min((n+3)&^3, len(b)):]
+	}
+	return attrs
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package ipdetect
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+func netlinkRoutes(ctx context.Context) ([]Route, error) {
+	return nil, fmt.Errorf("method=route requires linux (current %s)", runtime.GOOS)
+}
+
+func netlinkRules(ctx context.Context) ([]Rule, error) {
+	return nil, fmt.Errorf("policy routing requires linux (current %s)", runtime.GOOS)
+}
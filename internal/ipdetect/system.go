@@ -0,0 +1,129 @@
+package ipdetect
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// System is the part of the host the detector looks at. The default talks
+// to the real network stack; tests pass a fake through Options.System.
+// Every method gives up when ctx is done.
+type System interface {
+	// Routes returns the IPv4 routes of all routing tables.
+	Routes(ctx context.Context) ([]Route, error)
+	// Rules returns the IPv4 policy routing rules.
+	Rules(ctx context.Context) ([]Rule, error)
+	// Interfaces returns the network interfaces with their addresses.
+	Interfaces(ctx context.Context) ([]Interface, error)
+	// DialContext connects like net.Dialer.DialContext. Only the local
+	// address of the connection is used.
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	// WiFiLinks returns the WiFi interfaces that are associated with a
+	// network.
+	WiFiLinks(ctx context.Context) ([]WiFiLink, error)
+}
+
+// Route is an entry of an IPv4 routing table.
+type Route struct {
+	Iface       string
+	Destination net.IP
+	Mask        net.IPMask
+	// Gateway is nil for routes without a next hop, e.g. on a point-to-point
+	// link.
+	Gateway net.IP
+	// Flags are RTF_* flags, as in /proc/net/route.
+	Flags  uint32
+	Metric int
+	// Table is the routing table id, e.g. TableMain.
+	Table int
+}
+
+// Routing flags, see route(8).
+const (
+	RTFUp      = 0x1
+	RTFGateway = 0x2
+)
+
+// IsDefault reports whether r is a default route (0.0.0.0/0) that is up.
+func (r Route) IsDefault() bool {
+	if r.Flags&RTFUp == 0 {
+		return false
+	}
+	ones, _ := r.Mask.Size()
+	return ones == 0 && (r.Destination == nil || r.Destination.IsUnspecified())
+}
+
+// TableMain is the id of the main routing table.
+const TableMain = 254
+
+// Rule is a policy routing rule (ip rule) that looks up a table. Only the
+// selectors that matter for locating the default route are kept.
+type Rule struct {
+	Priority int
+	Table    int
+	// ToTable is false for rules that do not look up a table, e.g.
+	// blackhole or goto rules.
+	ToTable bool
+	// Fwmark and FwMask select packets by firewall mark; FwMask is 0 when
+	// the rule has no fwmark selector.
+	Fwmark uint32
+	FwMask uint32
+	// Invert is set for "not" rules.
+	Invert bool
+	// Selective is set when the rule also selects by prefix, interface, uid,
+	// protocol or port, so it does not apply to all traffic of the host.
+	Selective bool
+	// SuppressPrefixlen hides routes with a prefix length up to this value,
+	// -1 if unset.
+	SuppressPrefixlen int
+}
+
+// matches reports whether r applies to a packet with the firewall mark.
+func (r Rule) matches(mark uint32) bool {
+	if r.Selective {
+		return false
+	}
+	ok := mark&r.FwMask == r.Fwmark&r.FwMask
+	return ok != r.Invert
+}
+
+// Interface is a network interface and its addresses.
+type Interface struct {
+	Name  string
+	Flags net.Flags
+	Addrs []net.Addr
+}
+
+// WiFiLink is a WiFi interface associated with the network SSID.
+type WiFiLink struct {
+	Iface string
+	SSID  string
+}
+
+// hostSystem is the System of the machine the process runs on.
+type hostSystem struct{}
+
+func (hostSystem) Interfaces(ctx context.Context) ([]Interface, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Interface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		out = append(out, Interface{Name: iface.Name, Flags: iface.Flags, Addrs: addrs})
+	}
+	return out, nil
+}
+
+func (hostSystem) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d := net.Dialer{Timeout: 2 * time.Second}
+	return d.DialContext(ctx, network, address)
+}
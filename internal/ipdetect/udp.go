@@ -1,19 +1,22 @@
 package ipdetect
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 )
 
-func ipv4FromUDP() (net.IP, error) {
+func (d *Detector) ipv4FromUDP(ctx context.Context) (net.IP, error) {
 	// No packets need to be sent; Dial picks a source IP.
-	d := net.Dialer{Timeout: 2 * time.Second}
-
 	// Try a couple of public IPs; either should pick the default egress interface.
 	for _, addr := range []string{"8.8.8.8:80", "1.1.1.1:80"} {
-		c, err := d.Dial("udp", addr)
+		c, err := d.sys.DialContext(ctx, "udp", addr)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
 		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
@@ -25,9 +28,22 @@ func ipv4FromUDP() (net.IP, error) {
 			continue
 		}
 		ip := u.IP.To4()
-		if isUsableIPv4(ip) {
-			return ip, nil
+		if !isUsableIPv4(ip) {
+			continue
+		}
+		if !d.opt.Filter.allows(ip) {
+			return nil, fmt.Errorf("udp source %s is filtered out", ip)
+		}
+		if len(d.opt.Filter.ExcludeIfaces) > 0 {
+			ifname, err := d.ifaceOf(ctx, ip)
+			if err != nil {
+				return nil, err
+			}
+			if d.opt.Filter.excludesIface(ifname) {
+				return nil, fmt.Errorf("udp source %s is on iface %s, which is excluded by IP_EXCLUDE_IFACES", ip, ifname)
+			}
 		}
+		return ip, nil
 	}
 	return nil, errors.New("udp source-ip detection failed")
 }
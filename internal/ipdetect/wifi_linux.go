@@ -3,52 +3,52 @@
 package ipdetect
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mdlayher/wifi"
 )
 
-// wifiIfaceForSSID finds a WiFi interface which is currently associated with the
-// given SSID. It returns the interface name and the actual SSID.
-func wifiIfaceForSSID(targetSSID string) (string, string, error) {
-	targetSSID = strings.TrimSpace(targetSSID)
-	if targetSSID == "" {
-		return "", "", fmt.Errorf("%w: empty ssid", ErrWiFiSSIDUnavailable)
+// WiFiLinks asks nl80211 which WiFi interfaces are associated, and with
+// which SSID. The netlink socket follows the deadline of ctx, and
+// cancelling ctx interrupts a pending request.
+func (hostSystem) WiFiLinks(ctx context.Context) ([]WiFiLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-
 	c, err := wifi.New()
 	if err != nil {
-		return "", "", fmt.Errorf("%w: %v", ErrWiFiSSIDUnavailable, err)
+		return nil, fmt.Errorf("%w: %v", ErrWiFiSSIDUnavailable, err)
 	}
 	defer c.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(dl)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = c.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
 
 	ifis, err := c.Interfaces()
 	if err != nil {
-		return "", "", fmt.Errorf("%w: %v", ErrWiFiSSIDUnavailable, err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrWiFiSSIDUnavailable, err)
 	}
 
-	foundAny := false
-	var foundSSIDs []string
-
+	var links []WiFiLink
 	for _, ifi := range ifis {
 		bss, err := c.BSS(ifi)
-		if err != nil {
-			continue
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		ssid := strings.TrimSpace(string(bss.SSID))
-		if ssid == "" {
+		if err != nil {
 			continue
 		}
-		foundAny = true
-		foundSSIDs = append(foundSSIDs, fmt.Sprintf("%s=%q", ifi.Name, ssid))
-		if ssid == targetSSID {
-			return ifi.Name, ssid, nil
+		if ssid := strings.TrimSpace(string(bss.SSID)); ssid != "" {
+			links = append(links, WiFiLink{Iface: ifi.Name, SSID: ssid})
 		}
 	}
-
-	if !foundAny {
-		return "", "", fmt.Errorf("%w: no associated wifi interface found", ErrWiFiSSIDUnavailable)
-	}
-	return "", "", fmt.Errorf("%w: want %q, found %s", ErrWiFiSSIDNotMatched, targetSSID, strings.Join(foundSSIDs, ", "))
+	return links, nil
 }
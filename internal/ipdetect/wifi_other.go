@@ -2,8 +2,11 @@
 
 package ipdetect
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
-func wifiIfaceForSSID(targetSSID string) (string, string, error) {
-	return "", "", fmt.Errorf("%w: WIFI_SSID requires linux", ErrWiFiSSIDUnavailable)
+func (hostSystem) WiFiLinks(ctx context.Context) ([]WiFiLink, error) {
+	return nil, fmt.Errorf("%w: WIFI_SSID requires linux", ErrWiFiSSIDUnavailable)
 }
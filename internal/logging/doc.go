@@ -0,0 +1,3 @@
+package logging
+
+// Package logging builds the process-wide structured logger.
@@ -0,0 +1,3 @@
+package metrics
+
+// Package metrics exposes Prometheus metrics in the text exposition format.
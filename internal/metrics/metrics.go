@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+)
+
+var (
+	Detections = NewCounterVec("dnspod_updater_detections_total",
+		"IP detections by configured method and result.", "method", "result")
+	DetectedIP = NewGaugeVec("dnspod_updater_detected_ip_info",
+		"Currently detected IPv4 address; the value is always 1.", "ip", "source")
+
+	APIRequests = NewCounterVec("dnspod_updater_api_requests_total",
+		"DNSPod API calls by endpoint and status code.", "endpoint", "code")
+	APIDuration = NewHistogramVec("dnspod_updater_api_request_duration_seconds",
+		"DNSPod API call latency.", DefBuckets, "endpoint")
+
+	Modifications = NewCounterVec("dnspod_updater_modifications_total",
+		"Record writes performed.", "target")
+	LastSuccess = NewGaugeVec("dnspod_updater_last_success_timestamp_seconds",
+		"Unix time of the last successful check.", "target")
+	ConsecutiveFailures = NewGaugeVec("dnspod_updater_consecutive_failures",
+		"Failed checks since the last success.", "target")
+	BlockedUpdates = NewCounterVec("dnspod_updater_blocked_updates_total",
+		"Updates refused because the address is not public.", "target", "class")
+)
+
+// Handler serves all metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		defaultRegistry.write(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
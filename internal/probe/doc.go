@@ -0,0 +1,3 @@
+package probe
+
+// Package probe implements TCP/HTTP health probes used by failover mode.
@@ -0,0 +1,82 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// Type: "tcp" (default) or "http"
+	Type    string
+	Port    int
+	Path    string
+	Timeout time.Duration
+}
+
+type Prober struct {
+	opt Options
+	hc  *http.Client
+}
+
+func New(opt Options) *Prober {
+	opt.Type = strings.ToLower(strings.TrimSpace(opt.Type))
+	if opt.Type == "" {
+		opt.Type = "tcp"
+	}
+	if opt.Path == "" {
+		opt.Path = "/"
+	}
+	if !strings.HasPrefix(opt.Path, "/") {
+		opt.Path = "/" + opt.Path
+	}
+	if opt.Timeout == 0 {
+		opt.Timeout = 3 * time.Second
+	}
+	return &Prober{
+		opt: opt,
+		hc: &http.Client{
+			Timeout: opt.Timeout,
+			// A redirect still proves the service answers; don't follow it elsewhere.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Probe returns nil if the service at ip is healthy.
+func (p *Prober) Probe(ctx context.Context, ip net.IP) error {
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(p.opt.Port))
+	switch p.opt.Type {
+	case "tcp":
+		d := net.Dialer{Timeout: p.opt.Timeout}
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	case "http":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+p.opt.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := p.hc.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown HEALTH_CHECK_TYPE: %q", p.opt.Type)
+	}
+}
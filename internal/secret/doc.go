@@ -0,0 +1,3 @@
+package secret
+
+// Package secret wraps credentials so they cannot leak through logs, errors or JSON.
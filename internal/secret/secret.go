@@ -0,0 +1,89 @@
+package secret
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const redacted = "***"
+
+// String holds a credential such as a DNSPod token. It formats as "***" with
+// every fmt verb, in slog output and in JSON; only Reveal returns the value.
+type String struct {
+	v string
+}
+
+func New(v string) String { return String{v: v} }
+
+// Reveal returns the plain value, for the places that must send it.
+func (s String) Reveal() string { return s.v }
+
+func (s String) IsZero() bool { return s.v == "" }
+
+func (s String) String() string {
+	if s.v == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s String) GoString() string { return `secret.String("` + s.String() + `")` }
+
+func (s String) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(s.String()))
+}
+
+func (s String) LogValue() slog.Value { return slog.StringValue(s.String()) }
+
+func (s String) MarshalJSON() ([]byte, error) { return []byte(`"` + s.String() + `"`), nil }
+
+func (s String) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Redact replaces every occurrence of the secret, and of its parts around
+// ",", in text. DNSPod tokens have the form "id,token". Parts of 8 or more
+// characters are replaced wherever they appear; shorter ones, such as a
+// numeric id, only where they stand alone, so other numbers in text stay
+// readable.
+func (s String) Redact(text string) string {
+	if s.v == "" {
+		return text
+	}
+	text = strings.ReplaceAll(text, s.v, redacted)
+	for _, part := range strings.Split(s.v, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case len(part) >= 8:
+			text = strings.ReplaceAll(text, part, redacted)
+		default:
+			text = replaceWord(text, part)
+		}
+	}
+	return text
+}
+
+// replaceWord replaces the occurrences of word in text that are not part of
+// a longer run of letters and digits.
+func replaceWord(text, word string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(text, word)
+		if i < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		end := i + len(word)
+		if (i > 0 && isAlnum(text[i-1])) || (end < len(text) && isAlnum(text[end])) {
+			b.WriteString(text[:end])
+		} else {
+			b.WriteString(text[:i])
+			b.WriteString(redacted)
+		}
+		text = text[end:]
+	}
+}
+
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,79 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const token = "12345,0123456789abcdef"
+
+func TestStringIsRedacted(t *testing.T) {
+	s := New(token)
+	for _, verb := range []string{"%v", "%+v", "%s", "%q", "%#v", "%x", "%d"} {
+		if got := fmt.Sprintf(verb, s); got != redacted {
+			t.Errorf("Sprintf(%q) = %q, want %q", verb, got, redacted)
+		}
+	}
+	// Nested in a struct, which is how it usually ends up in a log line.
+	cfg := struct{ LoginToken String }{s}
+	for _, verb := range []string{"%v", "%+v", "%#v"} {
+		if got := fmt.Sprintf(verb, cfg); strings.Contains(got, "abcdef") || strings.Contains(got, "12345") {
+			t.Errorf("Sprintf(%q, struct) = %q", verb, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("config", "token", s, "cfg", cfg)
+	if out := buf.String(); strings.Contains(out, "abcdef") || !strings.Contains(out, `"token":"***"`) {
+		t.Errorf("slog output = %s", out)
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"LoginToken":"***"}` {
+		t.Errorf("json.Marshal = %s", b)
+	}
+	text, err := s.MarshalText()
+	if err != nil || string(text) != redacted {
+		t.Errorf("MarshalText = %q, %v", text, err)
+	}
+
+	if s.Reveal() != token {
+		t.Errorf("Reveal = %q", s.Reveal())
+	}
+}
+
+func TestZeroString(t *testing.T) {
+	var s String
+	if !s.IsZero() || s.String() != "" || fmt.Sprint(s) != "" {
+		t.Errorf("zero String = %q", s)
+	}
+	if got := s.Redact("login_token=&x=1"); got != "login_token=&x=1" {
+		t.Errorf("Redact = %q", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	s := New(token)
+	tests := []struct{ in, want string }{
+		{"login_token=12345,0123456789abcdef&format=json", "login_token=***&format=json"},
+		// The form encoding of the comma separates the parts.
+		{"login_token=12345%2C0123456789abcdef&format=json", "login_token=***%2C***&format=json"},
+		{"id 12345 token 0123456789abcdef", "id *** token ***"},
+		// The id is only hidden where it stands alone.
+		{"record 123456 and 9912345 kept", "record 123456 and 9912345 kept"},
+	}
+	for _, tt := range tests {
+		err := errors.New("dnspod http 500: " + tt.in)
+		if got := s.Redact(err.Error()); got != "dnspod http 500: "+tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+package server
+
+// Package server implements the embedded HTTP API: health checks, status and metrics.
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+	"github.com/hnrobert/dnspod-updater/internal/secret"
+	"github.com/hnrobert/dnspod-updater/internal/updater"
+)
+
+type Updater interface {
+	Status() []updater.TargetStatus
+	Trigger(ctx context.Context) error
+	// ReadyWithin is how recent the last successful check must be for
+	// /readyz to succeed. 0 only requires that the last check succeeded.
+	// It is asked on every request, so it follows config reloads.
+	ReadyWithin() time.Duration
+}
+
+type Options struct {
+	Updater Updater
+	// TriggerToken, if set, must be sent as "Authorization: Bearer <token>"
+	// to POST /trigger.
+	TriggerToken secret.String
+}
+
+// New returns the handler for /healthz, /readyz, /status, /trigger and /metrics.
+func New(opt Options) http.Handler {
+	s := &server{opt: opt}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /status", s.statusz)
+	mux.HandleFunc("POST /trigger", s.trigger)
+	mux.Handle("GET /metrics", metrics.Handler())
+	return mux
+}
+
+type server struct {
+	opt Options
+}
+
+// healthz only reports that the process is serving requests.
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	within := s.opt.Updater.ReadyWithin()
+	for _, st := range s.opt.Updater.Status() {
+		if reason := notReady(st, now, within); reason != "" {
+			http.Error(w, st.Target+": "+reason, http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func notReady(st updater.TargetStatus, now time.Time, within time.Duration) string {
+	switch {
+	case st.LastSuccess.IsZero():
+		if st.LastError != "" {
+			return "no successful check yet: " + st.LastError
+		}
+		return "no successful check yet"
+	case within > 0 && now.Sub(st.LastSuccess) > within:
+		return fmt.Sprintf("last successful check %s ago", now.Sub(st.LastSuccess).Round(time.Second))
+	case within == 0 && st.LastError != "":
+		return "last check failed: " + st.LastError
+	}
+	return ""
+}
+
+func (s *server) statusz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(struct {
+		Targets []updater.TargetStatus `json:"targets"`
+	}{s.opt.Updater.Status()})
+}
+
+// trigger runs an immediate check and reports its result. The optional
+// ?target= parameter must name one of the targets in /status.
+func (s *server) trigger(w http.ResponseWriter, r *http.Request) {
+	if !s.opt.TriggerToken.IsZero() {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.opt.TriggerToken.Reveal())) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if target := r.URL.Query().Get("target"); target != "" {
+		found := false
+		for _, st := range s.opt.Updater.Status() {
+			if st.Target == target {
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.Error(w, "unknown target: "+target, http.StatusNotFound)
+			return
+		}
+	}
+
+	err := s.opt.Updater.Trigger(r.Context())
+	resp := struct {
+		OK      bool                   `json:"ok"`
+		Error   string                 `json:"error,omitempty"`
+		Targets []updater.TargetStatus `json:"targets"`
+	}{OK: err == nil, Targets: s.opt.Updater.Status()}
+	code := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		code = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
@@ -0,0 +1,3 @@
+package state
+
+// Package state persists small pieces of updater state across restarts.
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record is the persisted state of a single DNSPod record.
+type Record struct {
+	// Modifications holds the time of every write sent to DNSPod within the
+	// last budget window.
+	Modifications []time.Time `json:"modifications,omitempty"`
+	// LockedUntil is set when DNSPod reported the record as locked.
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+	// OfflineDisabled is set while the record is disabled because the host
+	// was offline, so it is enabled again even after a restart.
+	OfflineDisabled bool `json:"offline_disabled,omitempty"`
+}
+
+type file struct {
+	Records map[string]*Record `json:"records"`
+}
+
+// Store is a JSON state file. With an empty path it only keeps state in memory.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data file
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: file{Records: map[string]*Record{}}}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Create the file now so an unwritable path fails at startup
+		// rather than on the first write.
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		if err := s.save(); err != nil {
+			return nil, fmt.Errorf("create state file %s: %w", path, err)
+		}
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, fmt.Errorf("decode state file %s: %w", path, err)
+	}
+	if s.data.Records == nil {
+		s.data.Records = map[string]*Record{}
+	}
+	return s, nil
+}
+
+// Record returns a copy of the state for recordID.
+func (s *Store) Record(recordID int) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.data.Records[strconv.Itoa(recordID)]
+	if !ok {
+		return Record{}
+	}
+	out := *r
+	out.Modifications = append([]time.Time(nil), r.Modifications...)
+	return out
+}
+
+// Update applies fn to the state for recordID and writes the file.
+func (s *Store) Update(recordID int, fn func(r *Record)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strconv.Itoa(recordID)
+	r, ok := s.data.Records[key]
+	if !ok {
+		r = &Record{}
+		s.data.Records[key] = r
+	}
+	fn(r)
+	return s.save()
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename so a crash never leaves a truncated file.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/state"
+)
+
+// ErrWriteDeferred is returned when a write was held back to stay within the
+// per-record modification budget or because DNSPod locked the record.
+var ErrWriteDeferred = errors.New("write deferred")
+
+const (
+	budgetWindow = time.Hour
+	// lockBackoff is how long DNSPod keeps a record locked after too many
+	// no-change modifications.
+	lockBackoff = time.Hour
+)
+
+// reserveWrite checks the modification budget of a record before a write.
+func (u *Updater) reserveWrite(recordID int) error {
+	now := time.Now()
+	st := u.opt.State.Record(recordID)
+	if now.Before(st.LockedUntil) {
+		return fmt.Errorf("%w: record %d locked by DNSPod until %s", ErrWriteDeferred, recordID, st.LockedUntil.Format(time.RFC3339))
+	}
+	limit := u.opt.Config.ModifyLimitPerHour
+	if limit <= 0 {
+		return nil
+	}
+	recent := recentWrites(st.Modifications, now)
+	if len(recent) >= limit {
+		next := recent[0].Add(budgetWindow)
+		return fmt.Errorf("%w: record %d reached %d modifications per hour, next write allowed at %s", ErrWriteDeferred, recordID, limit, next.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// recordWrite accounts a write that reached DNSPod and starts the lock
+// backoff when DNSPod reports the record as locked.
+func (u *Updater) recordWrite(recordID int, writeErr error) {
+	now := time.Now()
+	var apiErr *dnspod.APIError
+	locked := errors.As(writeErr, &apiErr) && apiErr.Locked()
+	err := u.opt.State.Update(recordID, func(r *state.Record) {
+		r.Modifications = append(recentWrites(r.Modifications, now), now)
+		if locked {
+			r.LockedUntil = now.Add(lockBackoff)
+		}
+	})
+	if locked {
+		u.logger().Warn("record locked by DNSPod, backing off", "record_id", recordID, "until", now.Add(lockBackoff))
+	}
+	if err != nil {
+		u.logger().Error("save state failed", "error", err)
+	}
+}
+
+func recentWrites(ts []time.Time, now time.Time) []time.Time {
+	var out []time.Time
+	for _, t := range ts {
+		if now.Sub(t) < budgetWindow {
+			out = append(out, t)
+		}
+	}
+	return out
+}
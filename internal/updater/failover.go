@@ -0,0 +1,73 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// failover tracks the health of the primary address with hysteresis: the
+// record flips to the backup after FailThreshold consecutive failed probes and
+// back to the primary after RecoverThreshold consecutive successful ones.
+type failover struct {
+	onBackup  bool
+	failures  int
+	successes int
+}
+
+func (u *Updater) failoverValue(ctx context.Context, primary net.IP) (string, error) {
+	if u.opt.Prober == nil {
+		return "", errors.New("failover mode requires a health prober")
+	}
+	cfg := u.opt.Config
+	fo := &u.fo
+
+	perr := u.opt.Prober.Probe(ctx, primary)
+	if perr != nil {
+		fo.failures++
+		fo.successes = 0
+		u.logger().Warn("health check of primary failed", append([]any{"ip", primary, "failures", fo.failures, "threshold", cfg.FailoverFailThreshold}, errArgs(perr)...)...)
+	} else {
+		fo.successes++
+		fo.failures = 0
+	}
+
+	switch {
+	case !fo.onBackup && fo.failures >= cfg.FailoverFailThreshold:
+		fo.onBackup = true
+		fo.successes = 0
+		u.logger().Warn("failover: primary unhealthy, switching to backup", "ip", primary, "failures", fo.failures, "backup", cfg.FailoverBackup)
+	case fo.onBackup && fo.successes >= cfg.FailoverRecoverThreshold:
+		fo.onBackup = false
+		fo.failures = 0
+		u.logger().Info("failover: primary healthy again, switching back from backup", "ip", primary, "successes", fo.successes, "backup", cfg.FailoverBackup)
+	}
+
+	if !fo.onBackup {
+		return primary.String(), nil
+	}
+	backup, err := resolveIPv4(ctx, cfg.FailoverBackup)
+	if err != nil {
+		return "", fmt.Errorf("resolve FAILOVER_BACKUP: %w", err)
+	}
+	return backup.String(), nil
+}
+
+// resolveIPv4 accepts either a literal IPv4 address or a host name.
+func resolveIPv4(ctx context.Context, s string) (net.IP, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			return v4, nil
+		}
+		return nil, fmt.Errorf("%q is not an IPv4 address", s)
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", s)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no IPv4 address for %q", s)
+	}
+	return ips[0].To4(), nil
+}
@@ -0,0 +1,75 @@
+package updater
+
+import (
+	"context"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/state"
+)
+
+// offline tracks how long detection has been failing, so the record can be
+// disabled instead of pointing at an address we may no longer own.
+type offline struct {
+	since time.Time
+	// disabled mirrors the OfflineDisabled flag of the state file, so
+	// failing checks don't look the record up again.
+	disabled bool
+}
+
+// markOffline records a failed detection. Once OFFLINE_DISABLE_AFTER has
+// elapsed since the first failure, the record is disabled via Record.Status.
+func (u *Updater) markOffline(ctx context.Context) error {
+	grace := u.opt.Config.OfflineDisableAfter
+	if grace <= 0 || u.off.disabled {
+		return nil
+	}
+	now := time.Now()
+	if u.off.since.IsZero() {
+		u.off.since = now
+		u.logger().Warn("host offline, record will be disabled", "grace", grace)
+		return nil
+	}
+	if now.Sub(u.off.since) < grace {
+		return nil
+	}
+
+	common := u.commonRequest()
+	rec, err := u.resolveRecord(ctx, common)
+	if err != nil {
+		return err
+	}
+	if !rec.Enabled {
+		// Already disabled: by us before a restart, or by hand, in which
+		// case it is left to whoever did that.
+		u.off.disabled = u.opt.State.Record(rec.ID).OfflineDisabled
+		return nil
+	}
+	u.logger().Warn("host offline, disabling record", "record_id", rec.ID, "offline_for", now.Sub(u.off.since).Round(time.Second))
+	if err := u.setRecordStatus(ctx, common, rec, "disable"); err != nil {
+		return err
+	}
+	u.setOfflineDisabled(rec.ID, true)
+	return nil
+}
+
+// needsReenable reports whether the record must be enabled again now that
+// detection has recovered: markOffline disabled it, here or before a
+// restart, and it is still disabled. Records disabled by hand stay untouched.
+func (u *Updater) needsReenable(rec record) bool {
+	if !u.off.disabled && !u.opt.State.Record(rec.ID).OfflineDisabled {
+		return false
+	}
+	return !rec.Enabled
+}
+
+// setOfflineDisabled records whether markOffline disabled the record. Dry
+// runs don't touch the state file.
+func (u *Updater) setOfflineDisabled(recordID int, disabled bool) {
+	u.off.disabled = disabled
+	if u.opt.Config.DryRun || u.opt.State.Record(recordID).OfflineDisabled == disabled {
+		return
+	}
+	if err := u.opt.State.Update(recordID, func(r *state.Record) { r.OfflineDisabled = disabled }); err != nil {
+		u.logger().Error("save state failed", "error", err)
+	}
+}
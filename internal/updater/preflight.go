@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+)
+
+// ErrPreflight is returned by Run when the startup check finds a setting
+// DNSPod will not accept.
+var ErrPreflight = errors.New("preflight failed")
+
+// preflight checks the address to publish, the token, the domain, the
+// record, the line and the TTL once at startup, so a wrong config fails right
+// away instead of at every interval. The domain id it resolves is used for
+// later requests.
+func (u *Updater) preflight(ctx context.Context) error {
+	cfg := u.opt.Config
+	common := u.commonRequest()
+
+	if err := u.preflightAddresses(ctx); err != nil {
+		return err
+	}
+
+	user, err := u.opt.DNSPod.UserDetail(ctx, common)
+	if err != nil {
+		return fmt.Errorf("check token (User.Detail): %w", err)
+	}
+	dom, err := u.opt.DNSPod.DomainInfo(ctx, common)
+	if err != nil {
+		return fmt.Errorf("look up domain (Domain.Info): %w", err)
+	}
+	u.domainID = int(dom.Domain.ID)
+	grade := dom.Domain.Grade.String()
+
+	if _, err := u.resolveRecord(ctx, u.commonRequest()); err != nil {
+		if errors.Is(err, errNoRecord) {
+			return fmt.Errorf("%w: %v; create it first, e.g. with \"dnspod-updater init\"", ErrPreflight, err)
+		}
+		return err
+	}
+
+	if id := cfg.RecordLineID; id != "" {
+		lines, err := u.opt.DNSPod.RecordLine(ctx, common, grade)
+		if err != nil {
+			return fmt.Errorf("list lines (Record.Line): %w", err)
+		}
+		found := false
+		for _, v := range lines.LineIDs {
+			if v.String() == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: DNSPOD_RECORD_LINE_ID %q is not available for %s (%s)", ErrPreflight, id, dom.Domain.Name, grade)
+		}
+	}
+	if min, ok := dnspod.MinTTL(grade); !ok {
+		u.logger().Warn("unknown domain grade, DNSPOD_TTL is not checked", "grade", grade)
+	} else if cfg.TTL > 0 && cfg.TTL < min {
+		return fmt.Errorf("%w: DNSPOD_TTL %d is below the minimum %d for %s (%s)", ErrPreflight, cfg.TTL, min, dom.Domain.Name, grade)
+	}
+
+	u.logger().Info("preflight ok", "user_id", user.Info.User.ID, "domain_id", u.domainID, "grade", grade)
+	return nil
+}
@@ -0,0 +1,75 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/ipdetect"
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+)
+
+// ErrAddressBlocked is returned when the value to publish is a private,
+// CGNAT, documentation or bogon address and the record is not marked
+// internal.
+var ErrAddressBlocked = errors.New("address not publishable")
+
+// preflightAddresses fails when the address the record would get right now
+// is not publishable, so a deployment that only ever sees a LAN address
+// stops at startup with a clear message instead of failing every interval.
+// A detection failure is left to the regular checks.
+func (u *Updater) preflightAddresses(ctx context.Context) error {
+	cfg := u.opt.Config
+	if cfg.RecordInternal {
+		return nil
+	}
+	// names[i] says where values[i] comes from.
+	var values, names []string
+	if cfg.UpdateMode == config.ModeFailover {
+		values = append(values, cfg.FailoverPrimary, cfg.FailoverBackup)
+		names = append(names, "FAILOVER_PRIMARY", "FAILOVER_BACKUP")
+	}
+	if cfg.UpdateMode != config.ModeFailover || cfg.FailoverPrimary == "" {
+		ip, src, err := u.opt.Detector.DetectIPv4(ctx)
+		if err != nil {
+			return nil
+		}
+		values = append(values, ip.String())
+		names = append(names, "the detected address ("+src+")")
+	}
+	for i, v := range values {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			// Host names are checked once they resolve.
+			continue
+		}
+		if class := ipdetect.Classify(ip); class != ipdetect.ClassPublic {
+			return fmt.Errorf("%w: %s %s is a %s address and every update would be refused; set DNSPOD_RECORD_INTERNAL=true if %s belongs to an internal zone, or IP_SCOPE=public to only detect public addresses",
+				ErrPreflight, names[i], v, class, u.target())
+		}
+	}
+	return nil
+}
+
+// checkPublishable refuses values that would make a public record point at
+// an address nobody outside can reach, e.g. after detection fell back to a
+// LAN interface.
+func (u *Updater) checkPublishable(value string) error {
+	if u.opt.Config.RecordInternal {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	class := ipdetect.Classify(ip)
+	if class == ipdetect.ClassPublic {
+		return nil
+	}
+	metrics.BlockedUpdates.Inc(u.target(), string(class))
+	u.logger().Error("refusing to publish non-public address, record left unchanged",
+		"ip", value, "class", class, "hint", "set DNSPOD_RECORD_INTERNAL=true if the record belongs to an internal zone")
+	return fmt.Errorf("%w: %s is a %s address", ErrAddressBlocked, value, class)
+}
@@ -0,0 +1,123 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+)
+
+// errNoRecord means Record.List found no record to update.
+var errNoRecord = errors.New("no matching record")
+
+// record is the subset of a DNSPod record the updater works with.
+type record struct {
+	ID      int
+	Name    string
+	Type    string
+	Line    string
+	LineID  string
+	Value   string
+	TTL     int
+	Enabled bool
+}
+
+func newRecord(r dnspod.Record) record {
+	return record{
+		ID:      int(r.ID),
+		Name:    strings.TrimSpace(r.Name.String()),
+		Type:    strings.TrimSpace(r.Type.String()),
+		Line:    strings.TrimSpace(r.Line.String()),
+		LineID:  strings.TrimSpace(r.LineID.String()),
+		Value:   strings.TrimSpace(r.Value.String()),
+		TTL:     int(r.TTL),
+		Enabled: bool(r.Enabled),
+	}
+}
+
+// target names the managed record in logs and metrics, e.g. "www.example.com".
+func (u *Updater) target() string {
+	domain := u.opt.Config.Domain
+	if domain == "" {
+		domain = "domain_id:" + strconv.Itoa(u.opt.Config.DomainID)
+	}
+	sub := u.opt.Config.SubDomain
+	if sub == "" || sub == "@" {
+		return domain
+	}
+	return sub + "." + domain
+}
+
+func (u *Updater) commonRequest() dnspod.CommonRequest {
+	domainID := u.opt.Config.DomainID
+	if domainID == 0 {
+		domainID = u.domainID
+	}
+	return dnspod.CommonRequest{
+		LoginToken:   u.opt.Config.LoginToken,
+		Format:       u.opt.Config.Format,
+		Lang:         u.opt.Config.Lang,
+		ErrorOnEmpty: u.opt.Config.ErrorOnEmpty,
+		Domain:       u.opt.Config.Domain,
+		DomainID:     domainID,
+	}
+}
+
+// resolveRecord loads the target record, either by DNSPOD_RECORD_ID (or the
+// id resolved earlier) or by looking up (domain + sub_domain) with Record.List.
+func (u *Updater) resolveRecord(ctx context.Context, common dnspod.CommonRequest) (record, error) {
+	recordID := u.opt.Config.RecordID
+	if recordID <= 0 {
+		recordID = u.resolvedID
+	}
+	if recordID > 0 {
+		info, err := u.opt.DNSPod.RecordInfo(ctx, common, recordID)
+		if err != nil {
+			return record{}, fmt.Errorf("Record.Info failed: %w", err)
+		}
+		rec := newRecord(info.Record)
+		rec.ID = recordID
+		u.logger().Debug("target record", "record_id", rec.ID, "name", rec.Name, "value", rec.Value)
+		return rec, nil
+	}
+
+	// Resolve record id by (domain + sub_domain). Pick the first matching record.
+	list, err := u.opt.DNSPod.RecordList(ctx, common, dnspod.RecordListParams{
+		SubDomain:  u.opt.Config.SubDomain,
+		RecordType: u.opt.Config.RecordType,
+		Offset:     0,
+		Length:     100,
+	})
+	if dnspod.KindOf(err) == dnspod.KindRecordNotFound {
+		return record{}, fmt.Errorf("%w for sub_domain=%q: %w", errNoRecord, u.opt.Config.SubDomain, err)
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("Record.List failed: %w", err)
+	}
+	if len(list.Records) == 0 {
+		return record{}, fmt.Errorf("%w for sub_domain=%q", errNoRecord, u.opt.Config.SubDomain)
+	}
+
+	// Prefer exact type match (e.g. A). Otherwise just take the first record.
+	idx := 0
+	wantType := strings.ToUpper(strings.TrimSpace(u.opt.Config.RecordType))
+	if wantType != "" {
+		for i := range list.Records {
+			if strings.ToUpper(strings.TrimSpace(list.Records[i].Type.String())) == wantType {
+				idx = i
+				break
+			}
+		}
+	}
+
+	rec := newRecord(list.Records[idx])
+	if rec.ID <= 0 {
+		return record{}, fmt.Errorf("invalid record id from Record.List: %d", rec.ID)
+	}
+	u.resolvedID = rec.ID
+	u.logger().Info("resolved record", "record_id", rec.ID, "name", rec.Name, "type", rec.Type, "line_id", rec.LineID, "value", rec.Value)
+	return rec, nil
+}
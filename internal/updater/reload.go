@@ -0,0 +1,59 @@
+package updater
+
+import (
+	"context"
+	"time"
+)
+
+// Reload swaps in a new, already validated config together with the
+// components built from it (Detector, DNSPod, Prober). Logger, State and
+// PlanOutput of opt are ignored. Reload waits until Run has applied the new
+// options, so it only has an effect while Run is watching periodically.
+func (u *Updater) Reload(ctx context.Context, opt Options) error {
+	select {
+	case u.reloads <- opt:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// applyReload runs on the Run goroutine. It returns the new check interval,
+// or 0 if the ticker can stay as it is.
+func (u *Updater) applyReload(opt Options) time.Duration {
+	old := u.opt.Config
+	cfg := opt.Config
+	if cfg.CheckInterval <= 0 {
+		u.logger().Warn("reload: CHECK_INTERVAL must stay > 0 while running", "check_interval", old.CheckInterval)
+		cfg.CheckInterval = old.CheckInterval
+	}
+
+	u.mu.Lock()
+	oldTarget := u.target()
+	u.opt.Config = cfg
+	u.opt.Detector = opt.Detector
+	u.opt.DNSPod = opt.DNSPod
+	u.opt.Prober = opt.Prober
+	sameRecord := u.target() == oldTarget && cfg.RecordID == old.RecordID && cfg.RecordType == old.RecordType
+	if !sameRecord {
+		u.status = TargetStatus{}
+	}
+	u.mu.Unlock()
+
+	// The record may be looked up differently now; resolve it again.
+	u.resolvedID = 0
+	u.domainID = 0
+	if !sameRecord {
+		u.fo = failover{}
+		u.off = offline{}
+	}
+	if cfg.UpdateMode != old.UpdateMode || cfg.FailoverPrimary != old.FailoverPrimary || cfg.FailoverBackup != old.FailoverBackup {
+		u.fo = failover{}
+	}
+
+	u.logger().Info("config reloaded")
+	if cfg.CheckInterval != old.CheckInterval {
+		return cfg.CheckInterval
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+)
+
+// errCheckTimeout means a check ran out of CHECK_TIMEOUT. The next interval
+// starts over.
+var errCheckTimeout = errors.New("check timed out")
+
+// check runs a check within CHECK_TIMEOUT and updates the per-target
+// metrics and status.
+func (u *Updater) check(ctx context.Context) error {
+	err := u.checkWithTimeout(ctx)
+	now := time.Now()
+	target := u.target()
+	if err != nil {
+		metrics.ConsecutiveFailures.Inc(target)
+		u.setStatus(func(st *TargetStatus) {
+			st.LastCheck = now
+			st.LastError = err.Error()
+		})
+		return err
+	}
+	metrics.ConsecutiveFailures.Set(0, target)
+	metrics.LastSuccess.Set(float64(now.Unix()), target)
+	u.setStatus(func(st *TargetStatus) {
+		st.LastCheck = now
+		st.LastSuccess = now
+		st.LastError = ""
+	})
+	return nil
+}
+
+// checkWithTimeout runs checkAndUpdate under CHECK_TIMEOUT; 0 means no limit.
+func (u *Updater) checkWithTimeout(ctx context.Context) error {
+	t := u.opt.Config.CheckTimeout
+	if t <= 0 {
+		return u.checkAndUpdate(ctx)
+	}
+	cctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+	err := u.checkAndUpdate(cctx)
+	if err != nil && ctx.Err() == nil && errors.Is(cctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s: %w", errCheckTimeout, t, err)
+	}
+	return err
+}
+
+// syncWithRetry runs syncRecord, resolving the record id again via
+// Record.List once if the one resolved earlier no longer exists. DNSPod API
+// errors of kind KindTransient, which arrive as HTTP 200 and so are not
+// retried by the client, are retried up to HTTP_RETRIES times with the same
+// backoff. syncRecord reads the record before writing, so a retry never
+// repeats a write that was applied.
+func (u *Updater) syncWithRetry(ctx context.Context, want string) error {
+	cfg := u.opt.Config
+	delay := cfg.HTTPRetryBaseDelay
+	if delay <= 0 {
+		delay = 500 * time.Millisecond
+	}
+	reresolved, retries := false, 0
+	for {
+		err := u.syncRecord(ctx, want)
+		if err == nil {
+			return nil
+		}
+		var apiErr *dnspod.APIError
+		switch {
+		case dnspod.KindOf(err) == dnspod.KindRecordNotFound && cfg.RecordID == 0 && !reresolved:
+			reresolved = true
+			u.resolvedID = 0
+			u.logger().Warn("record not found, resolving record id again", errArgs(err)...)
+			continue
+		case errors.As(err, &apiErr) && apiErr.Kind() == dnspod.KindTransient:
+			if retries >= cfg.HTTPRetries {
+				return err
+			}
+			retries++
+		default:
+			return err
+		}
+		if m := cfg.HTTPRetryMaxDelay; m > 0 && delay > m {
+			delay = m
+		}
+		u.logger().Warn("transient dnspod error, retrying", append([]any{"attempt", retries, "delay", delay}, errArgs(err)...)...)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+// isFatal reports whether err cannot be fixed without changing the config,
+// so the daemon should exit instead of failing every interval. A configured
+// DNSPOD_RECORD_ID that does not exist is fatal too.
+func (u *Updater) isFatal(err error) bool {
+	if errors.Is(err, ErrWriteDeferred) {
+		return false
+	}
+	kind := dnspod.KindOf(err)
+	return kind.Fatal() || (kind == dnspod.KindRecordNotFound && u.opt.Config.RecordID > 0)
+}
@@ -0,0 +1,47 @@
+package updater
+
+import (
+	"time"
+)
+
+// TargetStatus is a snapshot of the state of one managed record.
+type TargetStatus struct {
+	Target      string    `json:"target"`
+	DetectedIP  string    `json:"detected_ip,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	WantValue   string    `json:"want_value,omitempty"`
+	RemoteValue string    `json:"remote_value,omitempty"`
+	RecordID    int       `json:"record_id,omitempty"`
+	LastCheck   time.Time `json:"last_check"`
+	LastSuccess time.Time `json:"last_success"`
+	LastUpdate  time.Time `json:"last_update"`
+	LastError   string    `json:"last_error,omitempty"`
+	// OnBackup is set in failover mode while the record points at the backup.
+	OnBackup bool `json:"on_backup,omitempty"`
+}
+
+// Status returns a snapshot of all targets. It is safe to call concurrently
+// with Run.
+func (u *Updater) Status() []TargetStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	st := u.status
+	st.Target = u.target()
+	return []TargetStatus{st}
+}
+
+// ReadyWithin is how recent the last successful check must be for the
+// updater to count as ready: READY_INTERVALS times CHECK_INTERVAL of the
+// current config, so it follows reloads. 0 means there is no interval.
+func (u *Updater) ReadyWithin() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cfg := u.opt.Config
+	return time.Duration(cfg.ReadyIntervals) * cfg.CheckInterval
+}
+
+func (u *Updater) setStatus(fn func(st *TargetStatus)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fn(&u.status)
+}
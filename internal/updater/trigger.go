@@ -0,0 +1,45 @@
+package updater
+
+import (
+	"context"
+)
+
+// Trigger requests an immediate check and waits for its result. Triggers
+// that arrive while a check is queued or running are coalesced into a
+// single check, and every caller receives its result.
+//
+// Trigger only has an effect while Run is watching periodically.
+func (u *Updater) Trigger(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case u.triggers <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runTriggered runs one check for first and all other pending triggers.
+func (u *Updater) runTriggered(ctx context.Context, first chan error) error {
+	waiters := []chan error{first}
+drain:
+	for {
+		select {
+		case w := <-u.triggers:
+			waiters = append(waiters, w)
+		default:
+			break drain
+		}
+	}
+	u.logger().Info("triggered check", "requests", len(waiters))
+	err := u.check(ctx)
+	for _, w := range waiters {
+		w <- err
+	}
+	return err
+}
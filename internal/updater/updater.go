@@ -4,49 +4,102 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
-	"strconv"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hnrobert/dnspod-updater/internal/config"
 	"github.com/hnrobert/dnspod-updater/internal/dnspod"
 	"github.com/hnrobert/dnspod-updater/internal/ipdetect"
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+	"github.com/hnrobert/dnspod-updater/internal/state"
 )
 
 type IPDetector interface {
-	DetectIPv4() (net.IP, string, error)
+	DetectIPv4(ctx context.Context) (net.IP, string, error)
+}
+
+type HealthProber interface {
+	Probe(ctx context.Context, ip net.IP) error
 }
 
 type DNSPodClient interface {
+	UserDetail(ctx context.Context, req dnspod.CommonRequest) (dnspod.UserDetailResponse, error)
+	DomainInfo(ctx context.Context, req dnspod.CommonRequest) (dnspod.DomainInfoResponse, error)
+	RecordLine(ctx context.Context, req dnspod.CommonRequest, grade string) (dnspod.RecordLineResponse, error)
 	RecordInfo(ctx context.Context, req dnspod.CommonRequest, recordID int) (dnspod.RecordInfoResponse, error)
 	RecordList(ctx context.Context, req dnspod.CommonRequest, p dnspod.RecordListParams) (dnspod.RecordListResponse, error)
 	RecordModify(ctx context.Context, req dnspod.CommonRequest, recordID int, p dnspod.ModifyRecordParams) (dnspod.RecordModifyResponse, error)
+	RecordStatus(ctx context.Context, req dnspod.CommonRequest, recordID int, status string) (dnspod.RecordStatusResponse, error)
 }
 
 type Options struct {
 	Config     config.Config
 	Detector   IPDetector
 	DNSPod     DNSPodClient
-	Logger     *log.Logger
+	Logger     *slog.Logger
 	StartDelay time.Duration
+	// Prober checks the primary address in failover mode.
+	Prober HealthProber
+	// State persists the modification budget. Defaults to an in-memory store.
+	State *state.Store
+	// PlanOutput receives the dry-run plan. Defaults to os.Stdout.
+	PlanOutput io.Writer
 }
 
 type Updater struct {
 	opt Options
+	fo  failover
+	off offline
+
+	// resolvedID caches the record id found via Record.List.
+	resolvedID int
+	// domainID caches the domain id found by the preflight check.
+	domainID int
+
+	// pending is set when dry-run mode planned at least one write.
+	pending bool
+
+	mu     sync.Mutex
+	status TargetStatus
+
+	// triggers carries on-demand check requests; see Trigger.
+	triggers chan chan error
+	// reloads carries new configs; see Reload.
+	reloads chan Options
 }
 
 func New(opt Options) *Updater {
 	if opt.Logger == nil {
-		opt.Logger = log.Default()
+		opt.Logger = slog.Default()
+	}
+	if opt.State == nil {
+		opt.State, _ = state.Open("")
+	}
+	if opt.PlanOutput == nil {
+		opt.PlanOutput = os.Stdout
+	}
+	return &Updater{
+		opt:      opt,
+		triggers: make(chan chan error),
+		reloads:  make(chan Options),
 	}
-	return &Updater{opt: opt}
 }
 
 func (u *Updater) Run(ctx context.Context) error {
+	if u.opt.Config.DryRun {
+		if err := u.runPreflight(ctx); err != nil {
+			return err
+		}
+		return u.plan(ctx)
+	}
+
 	if u.opt.StartDelay > 0 {
-		u.opt.Logger.Printf("start delay: %s", u.opt.StartDelay)
+		u.logger().Info("start delay", "duration", u.opt.StartDelay)
 		t := time.NewTimer(u.opt.StartDelay)
 		select {
 		case <-ctx.Done():
@@ -56,114 +109,207 @@ func (u *Updater) Run(ctx context.Context) error {
 		}
 	}
 
+	if err := u.runPreflight(ctx); err != nil {
+		return err
+	}
+
 	// Always run once on startup.
-	if err := u.checkAndUpdateOnce(ctx); err != nil {
-		u.opt.Logger.Printf("startup check failed: %v", err)
+	if err := u.check(ctx); err != nil {
+		if u.isFatal(err) {
+			return err
+		}
+		u.logger().Warn("startup check failed", errArgs(err)...)
 	}
 
 	if u.opt.Config.OneShot || u.opt.Config.CheckInterval <= 0 {
-		u.opt.Logger.Printf("exit after startup (ONESHOT=%v, CHECK_INTERVAL=%s)", u.opt.Config.OneShot, u.opt.Config.CheckInterval)
+		u.logger().Info("exit after startup", "oneshot", u.opt.Config.OneShot, "check_interval", u.opt.Config.CheckInterval)
 		return nil
 	}
 
 	ticker := time.NewTicker(u.opt.Config.CheckInterval)
 	defer ticker.Stop()
 
-	u.opt.Logger.Printf("watching IP changes every %s", u.opt.Config.CheckInterval)
+	u.logger().Info("watching IP changes", "check_interval", u.opt.Config.CheckInterval)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			if err := u.checkAndUpdateOnce(ctx); err != nil {
-				u.opt.Logger.Printf("periodic check failed: %v", err)
+			if err := u.check(ctx); err != nil {
+				if u.isFatal(err) {
+					return err
+				}
+				u.logger().Warn("periodic check failed", errArgs(err)...)
+			}
+		case opt := <-u.reloads:
+			if iv := u.applyReload(opt); iv > 0 {
+				ticker.Reset(iv)
+			}
+		case first := <-u.triggers:
+			err := u.runTriggered(ctx, first)
+			if err != nil {
+				if u.isFatal(err) {
+					return err
+				}
+				u.logger().Warn("triggered check failed", errArgs(err)...)
 			}
 		}
 	}
 }
 
-func (u *Updater) checkAndUpdateOnce(ctx context.Context) error {
-	ip, src, err := u.opt.Detector.DetectIPv4()
-	if err != nil {
-		if errors.Is(err, ipdetect.ErrWiFiSSIDNotMatched) || errors.Is(err, ipdetect.ErrWiFiSSIDUnavailable) {
-			u.opt.Logger.Printf("wifi ssid constraint not satisfied, skip: %v", err)
-			return nil
-		}
-		return fmt.Errorf("detect ip: %w", err)
+// runPreflight returns the preflight error if it is caused by the config.
+// Other failures, e.g. a network outage at boot, are logged and left to the
+// regular checks.
+func (u *Updater) runPreflight(ctx context.Context) error {
+	err := u.preflight(ctx)
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, ErrPreflight) || u.isFatal(err):
+		return err
+	default:
+		u.logger().Warn("preflight incomplete, continuing", errArgs(err)...)
+		return nil
 	}
-	want := ip.String()
-	u.opt.Logger.Printf("detected IPv4=%s via %s", want, src)
-
-	common := dnspod.CommonRequest{
-		LoginToken:   u.opt.Config.LoginToken,
-		Format:       u.opt.Config.Format,
-		Lang:         u.opt.Config.Lang,
-		ErrorOnEmpty: u.opt.Config.ErrorOnEmpty,
-		Domain:       u.opt.Config.Domain,
-		DomainID:     u.opt.Config.DomainID,
-	}
-
-	recordID := u.opt.Config.RecordID
-	var current string
-	var recordName string
-	var recordType string
-	var recordLine string
-	var recordLineID string
-
-	if recordID > 0 {
-		info, err := u.opt.DNSPod.RecordInfo(ctx, common, recordID)
+}
+
+// logger returns the logger with the target attribute set.
+func (u *Updater) logger() *slog.Logger {
+	return u.opt.Logger.With("target", u.target())
+}
+
+// errArgs returns the log attributes for err, including the DNSPod status
+// code when there is one.
+func errArgs(err error) []any {
+	args := []any{"error", err}
+	var apiErr *dnspod.APIError
+	if errors.As(err, &apiErr) {
+		args = append(args, "error_code", apiErr.Code)
+	}
+	return args
+}
+
+// plan runs a single check without writing anything and reports whether
+// changes are pending.
+func (u *Updater) plan(ctx context.Context) error {
+	u.pending = false
+	if err := u.check(ctx); err != nil {
+		return err
+	}
+	if u.pending {
+		return ErrChangesPending
+	}
+	fmt.Fprintln(u.opt.PlanOutput, "no changes")
+	return nil
+}
+
+// detectMethod is the method label of detection metrics. It is the
+// configured method, not the source that answered, so success and failure
+// are counted under the same label.
+func (u *Updater) detectMethod() string {
+	if u.opt.Config.IPDetectMethod == "" {
+		return "auto"
+	}
+	return u.opt.Config.IPDetectMethod
+}
+
+// checkAndUpdate detects the address and probes the primary once, then
+// brings the record in line with it.
+func (u *Updater) checkAndUpdate(ctx context.Context) error {
+	want, err := u.wantValue(ctx)
+	if err != nil || want == "" {
+		return err
+	}
+	return u.syncWithRetry(ctx, want)
+}
+
+// wantValue returns the value the record should have. It is empty when
+// detection was skipped and nothing else is to be done.
+func (u *Updater) wantValue(ctx context.Context) (string, error) {
+	var ip net.IP
+	if u.opt.Config.UpdateMode == config.ModeFailover && u.opt.Config.FailoverPrimary != "" {
+		primary, err := resolveIPv4(ctx, u.opt.Config.FailoverPrimary)
 		if err != nil {
-			return fmt.Errorf("Record.Info failed: %w", err)
+			return "", fmt.Errorf("resolve FAILOVER_PRIMARY: %w", err)
 		}
-		current = strings.TrimSpace(info.Record.Value)
-		recordName = strings.TrimSpace(info.Record.Name)
-		recordType = strings.TrimSpace(info.Record.Type)
-		recordLine = strings.TrimSpace(info.Record.Line)
-		recordLineID = strings.TrimSpace(info.Record.LineID)
-		u.opt.Logger.Printf("target record id=%d name=%q value=%q", recordID, recordName, current)
-	} else {
-		// Resolve record id by (domain + sub_domain). Pick the first matching record.
-		list, err := u.opt.DNSPod.RecordList(ctx, common, dnspod.RecordListParams{
-			SubDomain:  u.opt.Config.SubDomain,
-			RecordType: u.opt.Config.RecordType,
-			Offset:     0,
-			Length:     100,
+		ip = primary
+		u.setStatus(func(st *TargetStatus) {
+			st.DetectedIP = ip.String()
+			st.Source = "static"
 		})
+	} else {
+		detected, src, err := u.opt.Detector.DetectIPv4(ctx)
 		if err != nil {
-			return fmt.Errorf("Record.List failed: %w", err)
-		}
-		if len(list.Records) == 0 {
-			return fmt.Errorf("no records found for sub_domain=%q", u.opt.Config.SubDomain)
-		}
-
-		// Prefer exact type match (e.g. A). Otherwise just take the first record.
-		idx := 0
-		wantType := strings.ToUpper(strings.TrimSpace(u.opt.Config.RecordType))
-		if wantType != "" {
-			for i := range list.Records {
-				if strings.ToUpper(strings.TrimSpace(list.Records[i].Type)) == wantType {
-					idx = i
-					break
-				}
+			if ctx.Err() != nil {
+				// Cut short, so it says nothing about the host being offline.
+				return "", fmt.Errorf("detect ip: %w", err)
+			}
+			if errors.Is(err, ipdetect.ErrWiFiSSIDNotMatched) || errors.Is(err, ipdetect.ErrWiFiSSIDUnavailable) {
+				metrics.Detections.Inc(u.detectMethod(), "skipped")
+				u.logger().Info("wifi ssid constraint not satisfied, skip", errArgs(err)...)
+				return "", u.markOffline(ctx)
 			}
+			metrics.Detections.Inc(u.detectMethod(), "error")
+			if oerr := u.markOffline(ctx); oerr != nil {
+				u.logger().Warn("offline handling failed", errArgs(oerr)...)
+			}
+			return "", fmt.Errorf("detect ip: %w", err)
 		}
+		ip = detected
+		metrics.Detections.Inc(u.detectMethod(), "success")
+		metrics.DetectedIP.Reset()
+		metrics.DetectedIP.Set(1, ip.String(), src)
+		u.setStatus(func(st *TargetStatus) {
+			st.DetectedIP = ip.String()
+			st.Source = src
+		})
+		u.logger().Debug("detected IPv4", "ip", ip, "source", src)
+	}
+	u.off.since = time.Time{}
 
-		rec := list.Records[idx]
-		parsedID, err := strconv.Atoi(strings.TrimSpace(rec.ID))
-		if err != nil || parsedID <= 0 {
-			return fmt.Errorf("invalid record id from Record.List: %q", rec.ID)
+	want := ip.String()
+	if u.opt.Config.UpdateMode == config.ModeFailover {
+		v, err := u.failoverValue(ctx, ip)
+		if err != nil {
+			return "", err
 		}
-		recordID = parsedID
-		current = strings.TrimSpace(rec.Value)
-		recordName = strings.TrimSpace(rec.Name)
-		recordType = strings.TrimSpace(rec.Type)
-		recordLine = strings.TrimSpace(rec.Line)
-		recordLineID = strings.TrimSpace(rec.LineID)
-		u.opt.Logger.Printf("resolved record id=%d name=%q type=%q line_id=%q value=%q", recordID, recordName, recordType, recordLineID, current)
+		want = v
+	}
+	if err := u.checkPublishable(want); err != nil {
+		return "", err
+	}
+	return want, nil
+}
+
+// syncRecord loads the record and modifies, or re-enables, it so that it
+// points at want.
+func (u *Updater) syncRecord(ctx context.Context, want string) error {
+	common := u.commonRequest()
+	rec, err := u.resolveRecord(ctx, common)
+	if err != nil {
+		return err
 	}
+	u.setStatus(func(st *TargetStatus) {
+		st.WantValue = want
+		st.RemoteValue = rec.Value
+		st.RecordID = rec.ID
+		st.OnBackup = u.fo.onBackup
+	})
 
-	if current == want {
-		u.opt.Logger.Printf("no update needed (same IP)")
+	reenable := u.needsReenable(rec)
+	if rec.Value == want {
+		if !reenable {
+			u.setOfflineDisabled(rec.ID, false)
+			u.logger().Debug("no update needed", "record_id", rec.ID, "ip", want)
+			return nil
+		}
+		u.logger().Info("host back online, re-enabling record", "record_id", rec.ID)
+		if err := u.setRecordStatus(ctx, common, rec, "enable"); err != nil {
+			return err
+		}
+		u.setOfflineDisabled(rec.ID, false)
 		return nil
 	}
 
@@ -178,30 +324,34 @@ func (u *Updater) checkAndUpdateOnce(ctx context.Context) error {
 	useLineID := strings.TrimSpace(u.opt.Config.RecordLineID)
 	useLine := u.opt.Config.RecordLine
 	if useLineID == "" {
-		useLineID = recordLineID
-		useLine = recordLine
+		useLineID = rec.LineID
+		useLine = rec.Line
 	}
 
 	useType := u.opt.Config.RecordType
 	if strings.TrimSpace(useType) == "" {
-		useType = recordType
+		useType = rec.Type
 	}
 
-	_, err = u.opt.DNSPod.RecordModify(ctx, common, recordID, dnspod.ModifyRecordParams{
-		SubDomain:    recordName,
+	status := u.opt.Config.Status
+	if reenable {
+		status = "enable"
+		u.logger().Info("host back online, re-enabling record", "record_id", rec.ID)
+	}
+	err = u.modifyRecord(ctx, common, rec, dnspod.ModifyRecordParams{
+		SubDomain:    rec.Name,
 		RecordType:   useType,
 		RecordLine:   useLine,
 		RecordLineID: useLineID,
 		Value:        want,
 		MX:           u.opt.Config.MX,
 		TTL:          u.opt.Config.TTL,
-		Status:       u.opt.Config.Status,
+		Status:       status,
 		Weight:       weightPtr,
 	})
 	if err != nil {
-		return fmt.Errorf("Record.Modify failed: %w", err)
+		return err
 	}
-
-	u.opt.Logger.Printf("updated record to %s", want)
+	u.setOfflineDisabled(rec.ID, false)
 	return nil
 }
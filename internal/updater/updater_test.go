@@ -0,0 +1,744 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/config"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/dnspod/dnspodtest"
+	"github.com/hnrobert/dnspod-updater/internal/secret"
+)
+
+const testToken = "1,secret"
+
+// stubDetector returns ip, or err when set. With hang set it blocks until
+// ctx is done.
+type stubDetector struct {
+	ip   string
+	err  error
+	hang bool
+}
+
+func (d *stubDetector) DetectIPv4(ctx context.Context) (net.IP, string, error) {
+	if d.hang {
+		<-ctx.Done()
+		return nil, "", ctx.Err()
+	}
+	if d.err != nil {
+		return nil, "", d.err
+	}
+	return net.ParseIP(d.ip).To4(), "stub", nil
+}
+
+// countingProber fails every probe and counts them.
+type countingProber struct{ n int }
+
+func (p *countingProber) Probe(ctx context.Context, ip net.IP) error {
+	p.n++
+	return errors.New("connection refused")
+}
+
+type fixture struct {
+	srv      *dnspodtest.Server
+	det      *stubDetector
+	recordID int
+	plan     bytes.Buffer
+}
+
+// newFixture returns a fake server holding www.example.com A 192.0.2.1 and
+// a config that updates it. The tests use documentation addresses, so the
+// record is marked internal.
+func newFixture(t *testing.T) (*fixture, config.Config) {
+	t.Helper()
+	f := &fixture{srv: dnspodtest.NewServer(testToken), det: &stubDetector{ip: "192.0.2.1"}}
+	t.Cleanup(f.srv.Close)
+	f.srv.AddDomain("example.com", "DP_Free")
+	f.recordID = f.srv.AddRecord("example.com", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1"})
+
+	cfg := config.Config{
+		LoginToken:    secret.New(testToken),
+		Format:        "json",
+		DNSPodBaseURL: f.srv.URL,
+		Domain:        "example.com",
+		SubDomain:     "www",
+		RecordType:    "A",
+		RecordLine:    "默认",
+		Weight:        -1,
+		OneShot:       true,
+		UpdateMode:    config.ModeDetect,
+
+		RecordInternal: true,
+	}
+	return f, cfg
+}
+
+func (f *fixture) updater(cfg config.Config) *Updater {
+	return New(Options{
+		Config:     cfg,
+		Detector:   f.det,
+		DNSPod:     dnspod.NewClient(dnspod.ClientOptions{BaseURL: cfg.DNSPodBaseURL, RetryBaseDelay: time.Millisecond}),
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		PlanOutput: &f.plan,
+	})
+}
+
+func (f *fixture) value(t *testing.T) string {
+	t.Helper()
+	r, ok := f.srv.Record(f.recordID)
+	if !ok {
+		t.Fatal("record missing")
+	}
+	return r.Value
+}
+
+func TestRunUpdatesChangedIP(t *testing.T) {
+	f, cfg := newFixture(t)
+	f.det.ip = "198.51.100.7"
+
+	u := f.updater(cfg)
+	if err := u.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.value(t); v != "198.51.100.7" {
+		t.Errorf("value = %q", v)
+	}
+	st := u.Status()[0]
+	if st.RecordID != f.recordID || st.RemoteValue != "198.51.100.7" || st.LastUpdate.IsZero() {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestCheckSkipsUnchangedIP(t *testing.T) {
+	f, cfg := newFixture(t)
+	u := f.updater(cfg)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := u.check(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.srv.Calls("Record.Modify"); n != 0 {
+		t.Errorf("Record.Modify called %d times, want 0", n)
+	}
+	// The record is found with Record.List once and read by id afterwards.
+	if n := f.srv.Calls("Record.List"); n != 1 {
+		t.Errorf("Record.List called %d times, want 1", n)
+	}
+	if n := f.srv.Calls("Record.Info"); n != 2 {
+		t.Errorf("Record.Info called %d times, want 2", n)
+	}
+}
+
+func TestCheckResolvesRemovedRecordAgain(t *testing.T) {
+	f, cfg := newFixture(t)
+	u := f.updater(cfg)
+	ctx := context.Background()
+	if err := u.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone replaced the record in the console.
+	f.srv.RemoveRecord(f.recordID)
+	f.recordID = f.srv.AddRecord("example.com", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1"})
+	f.det.ip = "198.51.100.7"
+
+	if err := u.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.value(t); v != "198.51.100.7" {
+		t.Errorf("value = %q", v)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(cfg *config.Config)
+		wantKind  dnspod.ErrorKind
+		preflight bool
+	}{
+		{"bad token", func(cfg *config.Config) { cfg.LoginToken = secret.New("1,wrong") }, dnspod.KindAuth, false},
+		{"unknown domain", func(cfg *config.Config) { cfg.Domain = "example.org" }, dnspod.KindDomainNotFound, false},
+		{"missing record", func(cfg *config.Config) { cfg.SubDomain = "api" }, dnspod.KindUnknown, true},
+		{"unknown line", func(cfg *config.Config) { cfg.RecordLineID = "99" }, dnspod.KindUnknown, true},
+		{"ttl below grade minimum", func(cfg *config.Config) { cfg.TTL = 60 }, dnspod.KindUnknown, true},
+		{"documentation address for a public record", func(cfg *config.Config) { cfg.RecordInternal = false }, dnspod.KindUnknown, true},
+		{"private failover backup", func(cfg *config.Config) {
+			cfg.RecordInternal = false
+			cfg.UpdateMode = config.ModeFailover
+			cfg.FailoverPrimary = "8.8.8.8"
+			cfg.FailoverBackup = "192.168.1.10"
+		}, dnspod.KindUnknown, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, cfg := newFixture(t)
+			f.det.ip = "198.51.100.7"
+			tt.edit(&cfg)
+
+			err := f.updater(cfg).Run(context.Background())
+			if err == nil {
+				t.Fatal("Run succeeded")
+			}
+			if got := errors.Is(err, ErrPreflight); got != tt.preflight {
+				t.Errorf("errors.Is(%v, ErrPreflight) = %v", err, got)
+			}
+			if got := dnspod.KindOf(err); got != tt.wantKind {
+				t.Errorf("KindOf(%v) = %v, want %v", err, got, tt.wantKind)
+			}
+			if n := f.srv.Calls("Record.Modify"); n != 0 {
+				t.Errorf("Record.Modify called %d times", n)
+			}
+		})
+	}
+}
+
+func TestPreflightAddressMessage(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.RecordInternal = false
+	f.det.ip = "192.168.1.10"
+
+	err := f.updater(cfg).Run(context.Background())
+	if !errors.Is(err, ErrPreflight) || !strings.Contains(err.Error(), "192.168.1.10 is a private address") || !strings.Contains(err.Error(), "DNSPOD_RECORD_INTERNAL=true") {
+		t.Fatalf("Run = %v", err)
+	}
+	if n := len(f.srv.Requests()); n != 0 {
+		t.Errorf("%d DNSPod requests before failing", n)
+	}
+}
+
+// A grade the updater does not know must not make a TTL fatal.
+func TestPreflightUnknownGrade(t *testing.T) {
+	f, cfg := newFixture(t)
+	f.srv.AddDomain("example.net", "DP_Future")
+	f.srv.AddRecord("example.net", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1"})
+	cfg.Domain = "example.net"
+	cfg.TTL = 60
+
+	if err := f.updater(cfg).preflight(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A DNSPod outage at startup must not stop the daemon.
+func TestPreflightToleratesOutage(t *testing.T) {
+	f, cfg := newFixture(t)
+	f.srv.Inject("User.Detail", dnspodtest.Fault{HTTPStatus: 502})
+
+	if err := f.updater(cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.DryRun = true
+	f.det.ip = "198.51.100.7"
+
+	err := f.updater(cfg).Run(context.Background())
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("Run = %v, want ErrChangesPending", err)
+	}
+	if !strings.Contains(f.plan.String(), "192.0.2.1 -> 198.51.100.7") {
+		t.Errorf("plan = %q", f.plan.String())
+	}
+	if n := f.srv.Calls("Record.Modify"); n != 0 {
+		t.Errorf("Record.Modify called %d times", n)
+	}
+
+	f.plan.Reset()
+	f.det.ip = "192.0.2.1"
+	if err := f.updater(cfg).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.plan.String(); got != "no changes\n" {
+		t.Errorf("plan = %q", got)
+	}
+}
+
+func TestModifyBudget(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.ModifyLimitPerHour = 2
+	u := f.updater(cfg)
+	ctx := context.Background()
+
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		f.det.ip = ip
+		if err := u.check(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.det.ip = "198.51.100.3"
+	err := u.check(ctx)
+	if !errors.Is(err, ErrWriteDeferred) {
+		t.Fatalf("check = %v, want ErrWriteDeferred", err)
+	}
+	if u.isFatal(err) {
+		t.Error("deferred write is fatal")
+	}
+	if v := f.value(t); v != "198.51.100.2" {
+		t.Errorf("value = %q", v)
+	}
+}
+
+func TestLockedRecordBacksOff(t *testing.T) {
+	f, cfg := newFixture(t)
+	u := f.updater(cfg)
+	ctx := context.Background()
+	f.srv.Inject("Record.Modify", dnspodtest.Fault{Code: "21", Message: "记录被锁定"})
+
+	f.det.ip = "198.51.100.7"
+	if err := u.check(ctx); dnspod.KindOf(err) != dnspod.KindRateLimited {
+		t.Fatalf("check = %v, want rate_limited", err)
+	}
+	if until := u.opt.State.Record(f.recordID).LockedUntil; time.Until(until) < 59*time.Minute {
+		t.Errorf("LockedUntil = %v", until)
+	}
+
+	// No request reaches DNSPod while the lock lasts.
+	if err := u.check(ctx); !errors.Is(err, ErrWriteDeferred) {
+		t.Fatalf("check = %v, want ErrWriteDeferred", err)
+	}
+	if n := f.srv.Calls("Record.Modify"); n != 1 {
+		t.Errorf("Record.Modify called %d times, want 1", n)
+	}
+}
+
+// The account-wide API limit is not a record lock: the next check may write.
+func TestAPILimitDoesNotLockRecord(t *testing.T) {
+	f, cfg := newFixture(t)
+	u := f.updater(cfg)
+	ctx := context.Background()
+	f.srv.Inject("Record.Modify", dnspodtest.Fault{Code: "-2", Message: "API使用超出限制"})
+
+	f.det.ip = "198.51.100.7"
+	if err := u.check(ctx); dnspod.KindOf(err) != dnspod.KindRateLimited {
+		t.Fatalf("check = %v, want rate_limited", err)
+	}
+	if until := u.opt.State.Record(f.recordID).LockedUntil; !until.IsZero() {
+		t.Errorf("LockedUntil = %v, want unset", until)
+	}
+	if err := u.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.value(t); v != "198.51.100.7" {
+		t.Errorf("value = %q", v)
+	}
+}
+
+func TestOfflineDisablesAndReenables(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.OfflineDisableAfter = time.Nanosecond
+	u := f.updater(cfg)
+	ctx := context.Background()
+
+	f.det.err = errors.New("no route")
+	for i := 0; i < 2; i++ {
+		if err := u.check(ctx); err == nil {
+			t.Fatal("check succeeded")
+		}
+	}
+	if r, _ := f.srv.Record(f.recordID); r.Status != "disable" {
+		t.Fatalf("status = %q, want disable", r.Status)
+	}
+
+	f.det.err = nil
+	if err := u.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := f.srv.Record(f.recordID); r.Status != "enable" {
+		t.Errorf("status = %q, want enable", r.Status)
+	}
+	if n := f.srv.Calls("Record.Modify"); n != 0 {
+		t.Errorf("Record.Modify called %d times, want 0", n)
+	}
+}
+
+func TestTransientErrorProbesOnce(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.UpdateMode = config.ModeFailover
+	cfg.FailoverPrimary = "198.51.100.7"
+	cfg.FailoverBackup = "198.51.100.8"
+	cfg.FailoverFailThreshold = 2
+	cfg.FailoverRecoverThreshold = 2
+	prober := &countingProber{}
+	u := New(Options{
+		Config:   cfg,
+		DNSPod:   dnspod.NewClient(dnspod.ClientOptions{BaseURL: cfg.DNSPodBaseURL, Retries: 1, RetryBaseDelay: time.Millisecond}),
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Prober:   prober,
+		Detector: f.det,
+	})
+
+	outage := dnspodtest.Fault{HTTPStatus: 503}
+	f.srv.Inject("Record.List", outage, outage, outage, outage)
+	if err := u.check(context.Background()); dnspod.KindOf(err) != dnspod.KindTransient {
+		t.Fatalf("check = %v, want a transient error", err)
+	}
+	if prober.n != 1 {
+		t.Errorf("primary probed %d times in one check, want 1", prober.n)
+	}
+	if u.fo.onBackup {
+		t.Error("switched to backup after a single failed probe")
+	}
+	// The client retried once; the updater did not run the check again.
+	if n := f.srv.Calls("Record.List"); n != 2 {
+		t.Errorf("Record.List called %d times, want 2", n)
+	}
+}
+
+// DNSPod reports some transient failures as an API status with HTTP 200,
+// which the client does not retry.
+func TestTransientAPIErrorRetried(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.HTTPRetries = 1
+	cfg.HTTPRetryBaseDelay = time.Millisecond
+	f.det.ip = "198.51.100.7"
+	f.srv.Inject("Record.List", dnspodtest.Fault{Code: "-99", Message: "此功能暂停开放"})
+
+	if err := f.updater(cfg).check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.value(t); v != "198.51.100.7" {
+		t.Errorf("value = %q", v)
+	}
+	if n := f.srv.Calls("Record.List"); n != 2 {
+		t.Errorf("Record.List called %d times, want 2", n)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.CheckTimeout = 50 * time.Millisecond
+	cfg.OfflineDisableAfter = time.Nanosecond
+	u := f.updater(cfg)
+	ctx := context.Background()
+
+	f.det.hang = true
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		err := u.check(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errCheckTimeout) {
+			t.Fatalf("check = %v, want a check timeout", err)
+		}
+	}
+	// Not retried as a transient error.
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("two checks took %s", d)
+	}
+	// A detection that was cut short does not count as the host being offline.
+	if r, _ := f.srv.Record(f.recordID); r.Status == "disable" {
+		t.Error("record disabled after a timeout")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := u.check(canceled); !errors.Is(err, context.Canceled) || errors.Is(err, errCheckTimeout) {
+		t.Errorf("check = %v, want context.Canceled", err)
+	}
+}
+
+func TestOfflineReenablesAfterRestart(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.OfflineDisableAfter = time.Nanosecond
+	u := f.updater(cfg)
+	ctx := context.Background()
+
+	f.det.err = errors.New("no route")
+	for i := 0; i < 2; i++ {
+		_ = u.check(ctx)
+	}
+	if r, _ := f.srv.Record(f.recordID); r.Status != "disable" {
+		t.Fatalf("status = %q, want disable", r.Status)
+	}
+
+	// A new process with the same state file and an unchanged address.
+	f.det.err = nil
+	restarted := New(Options{
+		Config:   cfg,
+		Detector: f.det,
+		DNSPod:   dnspod.NewClient(dnspod.ClientOptions{BaseURL: cfg.DNSPodBaseURL}),
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		State:    u.opt.State,
+	})
+	if err := restarted.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := f.srv.Record(f.recordID); r.Status != "enable" {
+		t.Errorf("status = %q, want enable", r.Status)
+	}
+	if u.opt.State.Record(f.recordID).OfflineDisabled {
+		t.Error("offline flag still set after re-enabling")
+	}
+}
+
+func TestOfflineLeavesManuallyDisabledRecord(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.OfflineDisableAfter = time.Nanosecond
+	id := f.srv.AddRecord("example.com", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1", Status: "disable"})
+	f.srv.RemoveRecord(f.recordID)
+	u := f.updater(cfg)
+
+	if err := u.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := f.srv.Record(id); r.Status != "disable" {
+		t.Errorf("status = %q, want disable", r.Status)
+	}
+	if n := f.srv.Calls("Record.Status"); n != 0 {
+		t.Errorf("Record.Status called %d times", n)
+	}
+}
+
+func TestPublishPolicy(t *testing.T) {
+	tests := []struct {
+		ip        string
+		internal  bool
+		wantClass string
+	}{
+		{ip: "8.8.8.8"},
+		{ip: "192.168.1.10", wantClass: "private"},
+		{ip: "10.1.2.3", wantClass: "private"},
+		{ip: "172.20.0.1", wantClass: "private"},
+		{ip: "100.101.102.103", wantClass: "cgnat"},
+		{ip: "198.51.100.7", wantClass: "documentation"},
+		{ip: "198.18.0.1", wantClass: "bogon"},
+		{ip: "240.0.0.1", wantClass: "bogon"},
+		{ip: "192.168.1.10", internal: true},
+		{ip: "100.101.102.103", internal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			f, cfg := newFixture(t)
+			cfg.RecordInternal = tt.internal
+			f.det.ip = tt.ip
+			u := f.updater(cfg)
+
+			err := u.check(context.Background())
+			if tt.wantClass == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := f.value(t); got != tt.ip {
+					t.Errorf("value = %q, want %q", got, tt.ip)
+				}
+				return
+			}
+			if !errors.Is(err, ErrAddressBlocked) || !strings.Contains(err.Error(), "is a "+tt.wantClass+" address") {
+				t.Fatalf("check = %v, want ErrAddressBlocked for class %s", err, tt.wantClass)
+			}
+			if u.isFatal(err) {
+				t.Error("blocked address is fatal")
+			}
+			if got := f.value(t); got != "192.0.2.1" {
+				t.Errorf("value = %q, want it unchanged", got)
+			}
+			if n := f.srv.Calls("Record.Modify"); n != 0 {
+				t.Errorf("Record.Modify called %d times", n)
+			}
+		})
+	}
+}
+
+// switchProber reports the primary healthy unless down is set.
+type switchProber struct{ down bool }
+
+func (p *switchProber) Probe(ctx context.Context, ip net.IP) error {
+	if p.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestFailoverHysteresis(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.UpdateMode = config.ModeFailover
+	cfg.FailoverPrimary = "198.51.100.7"
+	cfg.FailoverBackup = "198.51.100.8"
+	cfg.FailoverFailThreshold = 2
+	cfg.FailoverRecoverThreshold = 2
+	prober := &switchProber{}
+	u := f.updater(cfg)
+	u.opt.Prober = prober
+	ctx := context.Background()
+
+	steps := []struct {
+		down bool
+		want string
+	}{
+		{false, "198.51.100.7"},
+		// A single failure, or failures that are not consecutive, keep the
+		// primary.
+		{true, "198.51.100.7"},
+		{false, "198.51.100.7"},
+		{true, "198.51.100.7"},
+		{true, "198.51.100.8"},
+		{true, "198.51.100.8"},
+		// Recovery needs RecoverThreshold successes in a row too.
+		{false, "198.51.100.8"},
+		{true, "198.51.100.8"},
+		{false, "198.51.100.8"},
+		{false, "198.51.100.7"},
+	}
+	for i, s := range steps {
+		prober.down = s.down
+		if err := u.check(ctx); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if v := f.value(t); v != s.want {
+			t.Fatalf("step %d (primary down=%v): value = %q, want %q", i, s.down, v, s.want)
+		}
+	}
+	if n := f.srv.Calls("Record.Modify"); n != 3 {
+		t.Errorf("Record.Modify called %d times, want 3", n)
+	}
+}
+
+// gatedDetector blocks every detection until the test releases it, so the
+// test knows when a check is running.
+type gatedDetector struct {
+	ip      string
+	err     error
+	calls   int
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGatedDetector(ip string) *gatedDetector {
+	return &gatedDetector{ip: ip, entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (d *gatedDetector) DetectIPv4(ctx context.Context) (net.IP, string, error) {
+	d.entered <- struct{}{}
+	<-d.release
+	d.calls++
+	if d.err != nil {
+		return nil, "", d.err
+	}
+	return net.ParseIP(d.ip), "stub", nil
+}
+
+// runInBackground starts u.Run and returns a function that stops it and
+// returns its error.
+func runInBackground(u *Updater) (stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- u.Run(ctx) }()
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestTriggerCoalesces(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.OneShot = false
+	cfg.CheckInterval = time.Hour
+	det := newGatedDetector("192.0.2.1")
+	u := f.updater(cfg)
+	u.opt.Detector = det
+	// Buffered so the test can see when triggers are queued.
+	u.triggers = make(chan chan error, 8)
+	stop := runInBackground(u)
+
+	<-det.entered // startup check
+	det.release <- struct{}{}
+
+	first := make(chan error, 1)
+	go func() { first <- u.Trigger(context.Background()) }()
+	<-det.entered
+
+	// These arrive while the first triggered check is running.
+	const queued = 5
+	results := make(chan error, queued)
+	for range queued {
+		go func() { results <- u.Trigger(context.Background()) }()
+	}
+	for len(u.triggers) < queued {
+		time.Sleep(time.Millisecond)
+	}
+	det.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatalf("first Trigger = %v", err)
+	}
+
+	// One check serves every queued trigger, and each gets its result.
+	<-det.entered
+	det.err = errors.New("no route to host")
+	det.release <- struct{}{}
+	for range queued {
+		if err := <-results; err == nil || !strings.Contains(err.Error(), "no route to host") {
+			t.Errorf("queued Trigger = %v, want the detection error", err)
+		}
+	}
+
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v", err)
+	}
+	if det.calls != 3 {
+		t.Errorf("%d checks, want 3 (startup, first trigger, coalesced triggers)", det.calls)
+	}
+}
+
+func TestReloadSwapsComponents(t *testing.T) {
+	f, cfg := newFixture(t)
+	cfg.OneShot = false
+	cfg.CheckInterval = time.Hour
+	cfg.ReadyIntervals = 3
+	u := f.updater(cfg)
+	if got := u.ReadyWithin(); got != 3*time.Hour {
+		t.Errorf("ReadyWithin = %s, want 3h", got)
+	}
+	stop := runInBackground(u)
+	defer stop()
+	if err := u.Trigger(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second account whose domain and record ids differ from the first,
+	// so ids cached before the reload would miss.
+	srv2 := dnspodtest.NewServer(testToken)
+	t.Cleanup(srv2.Close)
+	srv2.AddDomain("example.org", "DP_Free")
+	srv2.AddDomain("example.com", "DP_Free")
+	srv2.AddRecord("example.com", dnspodtest.Record{Name: "mail", Type: "A", Value: "192.0.2.9"})
+	id2 := srv2.AddRecord("example.com", dnspodtest.Record{Name: "www", Type: "A", Value: "192.0.2.1"})
+
+	next := cfg
+	next.DNSPodBaseURL = srv2.URL
+	next.CheckInterval = 2 * time.Minute
+	err := u.Reload(context.Background(), Options{
+		Config:   next,
+		Detector: &stubDetector{ip: "198.51.100.9"},
+		DNSPod:   dnspod.NewClient(dnspod.ClientOptions{BaseURL: srv2.URL, RetryBaseDelay: time.Millisecond}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(f.srv.Requests())
+	if err := u.Trigger(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, _ := srv2.Record(id2); r.Value != "198.51.100.9" {
+		t.Errorf("new account: value = %q, want the new detector's address", r.Value)
+	}
+	if n := len(f.srv.Requests()); n != before {
+		t.Errorf("%d requests to the old account after the reload", n-before)
+	}
+	if v := f.value(t); v != "192.0.2.1" {
+		t.Errorf("old account: value = %q", v)
+	}
+	if got := u.ReadyWithin(); got != 6*time.Minute {
+		t.Errorf("ReadyWithin after reload = %s, want 6m", got)
+	}
+}
@@ -0,0 +1,75 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hnrobert/dnspod-updater/internal/dnspod"
+	"github.com/hnrobert/dnspod-updater/internal/metrics"
+)
+
+// ErrChangesPending is returned by Run in dry-run mode when the plan is not empty.
+var ErrChangesPending = errors.New("changes pending")
+
+// All DNSPod write calls go through the helpers below, so dry-run mode can
+// print what would happen instead.
+
+func (u *Updater) modifyRecord(ctx context.Context, common dnspod.CommonRequest, rec record, p dnspod.ModifyRecordParams) error {
+	if u.opt.Config.DryRun {
+		line := p.RecordLine
+		if line == "" || (p.RecordLineID != "" && p.RecordLineID == rec.LineID) {
+			line = rec.Line
+		}
+		ttl := rec.TTL
+		if p.TTL > 0 {
+			ttl = p.TTL
+		}
+		u.planf("would modify record %d %s %s %s %s -> %s ttl %d", rec.ID, p.SubDomain, p.RecordType, line, rec.Value, p.Value, ttl)
+		return nil
+	}
+	if err := u.reserveWrite(rec.ID); err != nil {
+		return err
+	}
+	_, err := u.opt.DNSPod.RecordModify(ctx, common, rec.ID, p)
+	u.recordWrite(rec.ID, err)
+	if err != nil {
+		return fmt.Errorf("Record.Modify failed: %w", err)
+	}
+	metrics.Modifications.Inc(u.target())
+	u.setStatus(func(st *TargetStatus) {
+		st.RemoteValue = p.Value
+		st.LastUpdate = time.Now()
+	})
+	u.logger().Info("updated record", "record_id", rec.ID, "ip", p.Value, "previous", rec.Value)
+	return nil
+}
+
+func (u *Updater) setRecordStatus(ctx context.Context, common dnspod.CommonRequest, rec record, status string) error {
+	if u.opt.Config.DryRun {
+		from := "enable"
+		if !rec.Enabled {
+			from = "disable"
+		}
+		u.planf("would set record %d %s status %s -> %s", rec.ID, rec.Name, from, status)
+		return nil
+	}
+	if err := u.reserveWrite(rec.ID); err != nil {
+		return err
+	}
+	_, err := u.opt.DNSPod.RecordStatus(ctx, common, rec.ID, status)
+	u.recordWrite(rec.ID, err)
+	if err != nil {
+		return fmt.Errorf("Record.Status failed: %w", err)
+	}
+	metrics.Modifications.Inc(u.target())
+	u.setStatus(func(st *TargetStatus) { st.LastUpdate = time.Now() })
+	u.logger().Info("set record status", "record_id", rec.ID, "status", status)
+	return nil
+}
+
+func (u *Updater) planf(format string, args ...any) {
+	u.pending = true
+	fmt.Fprintf(u.opt.PlanOutput, format+"\n", args...)
+}